@@ -0,0 +1,391 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libopenstorage/openstorage/cluster"
+	"github.com/libopenstorage/openstorage/volume"
+	"github.com/lpabon/openstorage-docker-server/pkg/server"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config are the settings of the gateway. They are read from the YAML or
+// JSON config file, then overridden by the environment variables and the
+// command line flags.
+type config struct {
+	Endpoints  []string `yaml:"endpoints"`
+	Discover   bool     `yaml:"discover"`
+	PluginName string   `yaml:"pluginName"`
+	Driver     string   `yaml:"driver"`
+	Scope      string   `yaml:"scope"`
+	StateDir   string   `yaml:"stateDir"`
+	Managed    bool     `yaml:"managed"`
+	TokenFile  string   `yaml:"tokenFile"`
+	HostMounts string   `yaml:"hostMounts"`
+
+	Sockets        socketsConfig     `yaml:"sockets"`
+	Ports          portsConfig       `yaml:"ports"`
+	Timeouts       timeoutsConfig    `yaml:"timeouts"`
+	Log            logConfig         `yaml:"log"`
+	Secrets        secretsConfig     `yaml:"secrets"`
+	TLS            tlsConfig         `yaml:"tls"`
+	VolumeDefaults map[string]string `yaml:"volumeDefaults"`
+}
+
+// socketsConfig are the directories of the unix domain sockets
+type socketsConfig struct {
+	Mgmt    string `yaml:"mgmt"`
+	Plugin  string `yaml:"plugin"`
+	Cluster string `yaml:"cluster"`
+}
+
+// portsConfig are the TCP ports of the REST servers. Zero disables them.
+type portsConfig struct {
+	Mgmt    uint `yaml:"mgmt"`
+	Plugin  uint `yaml:"plugin"`
+	Cluster uint `yaml:"cluster"`
+}
+
+type timeoutsConfig struct {
+	Create time.Duration `yaml:"create"`
+	Mount  time.Duration `yaml:"mount"`
+	Remove time.Duration `yaml:"remove"`
+	// Shutdown is how long requests in progress can take to complete
+	// when the gateway is stopped.
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+type logConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type secretsConfig struct {
+	Type     string `yaml:"type"`
+	Location string `yaml:"location"`
+}
+
+type tlsConfig struct {
+	CA         string `yaml:"ca"`
+	Cert       string `yaml:"cert"`
+	Key        string `yaml:"key"`
+	ServerName string `yaml:"serverName"`
+	// Insecure allows endpoints which are not on this host without TLS
+	Insecure bool `yaml:"insecure"`
+}
+
+// defaultShutdownTimeout is how long requests in progress can take to
+// complete when the gateway is stopped.
+const defaultShutdownTimeout = 30 * time.Second
+
+// envVars are the environment variables overriding the settings of the
+// flags. When running as a Docker managed plugin, the settings changed with
+// `docker plugin set` are passed to the process as environment variables.
+var envVars = map[string]string{
+	"e":                  "SDK_ENDPOINT",
+	"discover":           "SDK_DISCOVER",
+	"p":                  "PLUGIN_NAME",
+	"d":                  "DRIVER",
+	"scope":              "SCOPE",
+	"state-dir":          "STATE_DIR",
+	"managed":            "PLUGIN_MANAGED",
+	"token-file":         "TOKEN_FILE",
+	"host-mounts":        "HOST_MOUNTS",
+	"mgmt-socket-dir":    "MGMT_SOCKET_DIR",
+	"plugin-socket-dir":  "PLUGIN_SOCKET_DIR",
+	"cluster-socket-dir": "CLUSTER_SOCKET_DIR",
+	"mgmt-port":          "MGMT_PORT",
+	"plugin-port":        "PLUGIN_PORT",
+	"cluster-port":       "CLUSTER_PORT",
+	"create-timeout":     "CREATE_TIMEOUT",
+	"mount-timeout":      "MOUNT_TIMEOUT",
+	"remove-timeout":     "REMOVE_TIMEOUT",
+	"shutdown-timeout":   "SHUTDOWN_TIMEOUT",
+	"log-level":          "LOG_LEVEL",
+	"log-format":         "LOG_FORMAT",
+	"secrets-type":       "SECRETS_TYPE",
+	"secrets-location":   "SECRETS_LOCATION",
+	"tls-ca":             "SDK_TLS_CA",
+	"tls-cert":           "SDK_TLS_CERT",
+	"tls-key":            "SDK_TLS_KEY",
+	"tls-server-name":    "SDK_TLS_SERVER_NAME",
+	"insecure":           "SDK_INSECURE",
+	"volume-defaults":    "VOLUME_DEFAULTS",
+}
+
+func defaultConfig() *config {
+	return &config{
+		Endpoints:  []string{"localhost:9100"},
+		PluginName: "osd-gateway",
+		Driver:     "fake",
+		Scope:      server.ScopeAuto,
+		StateDir:   "/var/lib/osd-gateway",
+		Sockets: socketsConfig{
+			Mgmt:    volume.DriverAPIBase,
+			Plugin:  volume.PluginAPIBase,
+			Cluster: cluster.APIBase,
+		},
+		Ports: portsConfig{
+			Mgmt:    2376,
+			Plugin:  2377,
+			Cluster: 2378,
+		},
+		Timeouts: timeoutsConfig{
+			Create:   server.DefaultCreateTimeout,
+			Mount:    server.DefaultMountTimeout,
+			Remove:   server.DefaultRemoveTimeout,
+			Shutdown: defaultShutdownTimeout,
+		},
+		Log: logConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// listValue is a flag for a comma separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// mapValue is a flag for a comma separated list of key=value pairs
+type mapValue map[string]string
+
+func (m *mapValue) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapValue) Set(value string) error {
+	*m = make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); len(pair) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		(*m)[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// newFlagSet returns the flags setting c, with the values of c as defaults
+func newFlagSet(c *config, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(configFile, "config", *configFile,
+		"YAML or JSON config file (CONFIG_FILE)")
+	fs.Var((*listValue)(&c.Endpoints), "e",
+		"Comma separated list of SDK endpoints, starting with the local node")
+	fs.BoolVar(&c.Discover, "discover", c.Discover,
+		"Discover the SDK endpoints of the other nodes in the cluster")
+	fs.StringVar(&c.PluginName, "p", c.PluginName,
+		"Name for our plugin")
+	fs.StringVar(&c.Driver, "d", c.Driver,
+		"Driver we want to use")
+	fs.StringVar(&c.Scope, "scope", c.Scope,
+		"Volume scope reported to Docker: global, local, or auto")
+	fs.StringVar(&c.StateDir, "state-dir", c.StateDir,
+		"Directory where the mount state is saved")
+	fs.BoolVar(&c.Managed, "managed", c.Managed,
+		"Run as a Docker managed plugin, serving only the plugin socket")
+	fs.StringVar(&c.TokenFile, "token-file", c.TokenFile,
+		"File with the default token used when a request does not provide one")
+	fs.StringVar(&c.HostMounts, "host-mounts", c.HostMounts,
+		"Directory where the host directory in which the SDK server mounts volumes is seen, "+
+			"if the gateway runs in its own mount namespace")
+	fs.StringVar(&c.Sockets.Mgmt, "mgmt-socket-dir", c.Sockets.Mgmt,
+		"Directory of the management API socket")
+	fs.StringVar(&c.Sockets.Plugin, "plugin-socket-dir", c.Sockets.Plugin,
+		"Directory of the volume plugin socket")
+	fs.StringVar(&c.Sockets.Cluster, "cluster-socket-dir", c.Sockets.Cluster,
+		"Directory of the cluster API socket")
+	fs.UintVar(&c.Ports.Mgmt, "mgmt-port", c.Ports.Mgmt,
+		"TCP port of the management API, 0 to disable it")
+	fs.UintVar(&c.Ports.Plugin, "plugin-port", c.Ports.Plugin,
+		"TCP port of the volume plugin API, 0 to disable it")
+	fs.UintVar(&c.Ports.Cluster, "cluster-port", c.Ports.Cluster,
+		"TCP port of the cluster API, 0 to disable it")
+	fs.DurationVar(&c.Timeouts.Create, "create-timeout", c.Timeouts.Create,
+		"Timeout to create a volume or start restoring a backup")
+	fs.DurationVar(&c.Timeouts.Mount, "mount-timeout", c.Timeouts.Mount,
+		"Timeout to mount or unmount a volume")
+	fs.DurationVar(&c.Timeouts.Remove, "remove-timeout", c.Timeouts.Remove,
+		"Timeout to remove a volume")
+	fs.DurationVar(&c.Timeouts.Shutdown, "shutdown-timeout", c.Timeouts.Shutdown,
+		"Time given to requests in progress to complete when stopping")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level,
+		"Log level: debug, info, warning, or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format,
+		"Log format: text or json")
+	fs.StringVar(&c.Secrets.Type, "secrets-type", c.Secrets.Type,
+		"Secret provider for the secret_key of encrypted volumes: file, env, or kv")
+	fs.StringVar(&c.Secrets.Location, "secrets-location", c.Secrets.Location,
+		"Directory for the file secret provider or file for the kv secret provider")
+	fs.StringVar(&c.TLS.CA, "tls-ca", c.TLS.CA,
+		"CA bundle used to verify the SDK endpoints")
+	fs.StringVar(&c.TLS.Cert, "tls-cert", c.TLS.Cert,
+		"Client certificate used for mutual TLS with the SDK endpoints")
+	fs.StringVar(&c.TLS.Key, "tls-key", c.TLS.Key,
+		"Key of the client certificate")
+	fs.StringVar(&c.TLS.ServerName, "tls-server-name", c.TLS.ServerName,
+		"Server name used to verify the certificate of the SDK endpoints")
+	fs.BoolVar(&c.TLS.Insecure, "insecure", c.TLS.Insecure,
+		"Send tokens in plaintext to SDK endpoints which are not on this host when TLS is not configured")
+	fs.Var((*mapValue)(&c.VolumeDefaults), "volume-defaults",
+		"Comma separated key=value volume options used when a volume is created without them")
+
+	// Show the environment variable of each flag in the help
+	fs.VisitAll(func(f *flag.Flag) {
+		if env, ok := envVars[f.Name]; ok {
+			f.Usage = fmt.Sprintf("%s (%s)", f.Usage, env)
+		}
+	})
+	return fs
+}
+
+// loadConfig returns the settings from the config file, the environment
+// variables and the command line arguments, in increasing priority.
+func loadConfig(args []string) (*config, error) {
+	// The arguments are parsed a first time to find the config file
+	configFile := os.Getenv("CONFIG_FILE")
+	if err := newFlagSet(defaultConfig(), &configFile).Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := defaultConfig()
+	if len(configFile) != 0 {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read config file %s: %v", configFile, err)
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("Invalid config file %s: %v", configFile, err)
+		}
+	}
+
+	fs := newFlagSet(c, &configFile)
+	for name, env := range envVars {
+		if v, ok := os.LookupEnv(env); ok && len(v) != 0 {
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("Invalid value %q for %s: %v", v, env, err)
+			}
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if len(c.Endpoints) == 0 {
+		return nil, fmt.Errorf("No SDK endpoint provided")
+	}
+	if c.Ports.Mgmt > 65535 || c.Ports.Plugin > 65535 || c.Ports.Cluster > 65535 {
+		return nil, fmt.Errorf("Invalid port, must be at most 65535")
+	}
+	return c, nil
+}
+
+// setupLogging applies the log settings
+func (c *config) setupLogging() error {
+	level, err := logrus.ParseLevel(c.Log.Level)
+	if err != nil {
+		return err
+	}
+
+	var formatter logrus.Formatter
+	switch c.Log.Format {
+	case "text", "":
+		formatter = &logrus.TextFormatter{}
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("Invalid log format %s. Must be text or json", c.Log.Format)
+	}
+
+	logrus.SetLevel(level)
+	logrus.SetFormatter(formatter)
+	return nil
+}
+
+// driverOptions returns the options of the volume plugin
+func (c *config) driverOptions() (*server.DriverOptions, error) {
+	var secrets server.SecretProvider
+	if len(c.Secrets.Type) != 0 {
+		var err error
+		secrets, err = server.NewSecretProvider(c.Secrets.Type, c.Secrets.Location)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup secrets: %v", err)
+		}
+	}
+
+	return &server.DriverOptions{
+		Scope:             c.Scope,
+		StateDir:          c.StateDir,
+		Secrets:           secrets,
+		TokenFile:         c.TokenFile,
+		DiscoverEndpoints: c.Discover,
+		TLS: &server.TLSOptions{
+			CAFile:     c.TLS.CA,
+			CertFile:   c.TLS.Cert,
+			KeyFile:    c.TLS.Key,
+			ServerName: c.TLS.ServerName,
+		},
+		Timeouts: server.Timeouts{
+			Create: c.Timeouts.Create,
+			Mount:  c.Timeouts.Mount,
+			Remove: c.Timeouts.Remove,
+		},
+		VolumeDefaults: c.VolumeDefaults,
+		HostMounts:     c.HostMounts,
+		Insecure:       c.TLS.Insecure,
+	}, nil
+}
+
+// reload applies the settings of n which can change without restarting the
+// gateway, and returns false if other settings changed.
+func (c *config) reload(n *config) (*server.DriverOptions, bool, error) {
+	if err := n.setupLogging(); err != nil {
+		return nil, false, err
+	}
+	c.Log = n.Log
+
+	updated := *c
+	updated.Scope = n.Scope
+	updated.TokenFile = n.TokenFile
+	updated.Timeouts = n.Timeouts
+	updated.Secrets = n.Secrets
+	updated.VolumeDefaults = n.VolumeDefaults
+	opts, err := updated.driverOptions()
+	if err != nil {
+		return nil, false, err
+	}
+
+	*c = updated
+	return opts, reflect.DeepEqual(c, n), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setEnv sets the environment variables, after clearing all the variables
+// read by the gateway. It returns a function restoring the environment.
+func setEnv(env map[string]string) func() {
+	saved := make(map[string]string)
+	names := []string{"CONFIG_FILE"}
+	for _, name := range envVars {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			saved[name] = v
+		}
+		os.Unsetenv(name)
+	}
+	for name, v := range env {
+		os.Setenv(name, v)
+	}
+
+	return func() {
+		for _, name := range names {
+			os.Unsetenv(name)
+		}
+		for name, v := range saved {
+			os.Setenv(name, v)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(file, []byte(`
+endpoints: [10.0.0.1:9100, 10.0.0.2:9100]
+pluginName: from-file
+scope: local
+timeouts:
+  create: 5m
+volumeDefaults:
+  repl: "2"
+`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		expected func(c *config)
+		fails    bool
+	}{
+		{
+			name:     "defaults",
+			expected: func(c *config) {},
+		},
+		{
+			name: "config file",
+			args: []string{"-config", file},
+			expected: func(c *config) {
+				c.Endpoints = []string{"10.0.0.1:9100", "10.0.0.2:9100"}
+				c.PluginName = "from-file"
+				c.Scope = "local"
+				c.Timeouts.Create = 5 * time.Minute
+				c.VolumeDefaults = map[string]string{"repl": "2"}
+			},
+		},
+		{
+			name: "config file from the environment",
+			env:  map[string]string{"CONFIG_FILE": file},
+			expected: func(c *config) {
+				c.Endpoints = []string{"10.0.0.1:9100", "10.0.0.2:9100"}
+				c.PluginName = "from-file"
+				c.Scope = "local"
+				c.Timeouts.Create = 5 * time.Minute
+				c.VolumeDefaults = map[string]string{"repl": "2"}
+			},
+		},
+		{
+			name: "environment overrides the config file",
+			env: map[string]string{
+				"PLUGIN_NAME":     "from-env",
+				"SDK_ENDPOINT":    "10.0.0.3:9100",
+				"CREATE_TIMEOUT":  "1m",
+				"VOLUME_DEFAULTS": "repl=3,size=10",
+			},
+			args: []string{"-config", file},
+			expected: func(c *config) {
+				c.Endpoints = []string{"10.0.0.3:9100"}
+				c.PluginName = "from-env"
+				c.Scope = "local"
+				c.Timeouts.Create = time.Minute
+				c.VolumeDefaults = map[string]string{"repl": "3", "size": "10"}
+			},
+		},
+		{
+			name: "flags override the environment",
+			env: map[string]string{
+				"PLUGIN_NAME": "from-env",
+				"SCOPE":       "global",
+			},
+			args: []string{"-config", file, "-p", "from-flag"},
+			expected: func(c *config) {
+				c.Endpoints = []string{"10.0.0.1:9100", "10.0.0.2:9100"}
+				c.PluginName = "from-flag"
+				c.Scope = "global"
+				c.Timeouts.Create = 5 * time.Minute
+				c.VolumeDefaults = map[string]string{"repl": "2"}
+			},
+		},
+		{
+			name:     "empty environment variables are ignored",
+			env:      map[string]string{"PLUGIN_NAME": ""},
+			expected: func(c *config) {},
+		},
+		{
+			name:  "invalid environment variable",
+			env:   map[string]string{"MOUNT_TIMEOUT": "soon"},
+			fails: true,
+		},
+		{
+			name:  "missing config file",
+			args:  []string{"-config", path.Join(dir, "missing.yaml")},
+			fails: true,
+		},
+		{
+			name:  "no endpoints",
+			args:  []string{"-e", " , "},
+			fails: true,
+		},
+		{
+			name:  "invalid port",
+			args:  []string{"-mgmt-port", "70000"},
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		restore := setEnv(tt.env)
+		c, err := loadConfig(tt.args)
+		restore()
+
+		if fails := err != nil; fails != tt.fails {
+			t.Errorf("%s: error %v, expected failure %v", tt.name, err, tt.fails)
+			continue
+		}
+		if tt.fails {
+			continue
+		}
+		expected := defaultConfig()
+		tt.expected(expected)
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("%s: config %+v, expected %+v", tt.name, c, expected)
+		}
+	}
+}
+
+func TestMapValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected map[string]string
+		fails    bool
+	}{
+		{value: "repl=2,size=10", expected: map[string]string{"repl": "2", "size": "10"}},
+		{value: " repl=2 , ,labels=a=b", expected: map[string]string{"repl": "2", "labels": "a=b"}},
+		{value: "", expected: map[string]string{}},
+		{value: "repl", fails: true},
+		{value: "=2", fails: true},
+	}
+
+	for _, tt := range tests {
+		m := make(mapValue)
+		err := m.Set(tt.value)
+		if fails := err != nil; fails != tt.fails {
+			t.Errorf("%q: error %v, expected failure %v", tt.value, err, tt.fails)
+			continue
+		}
+		if !tt.fails && !reflect.DeepEqual(map[string]string(m), tt.expected) {
+			t.Errorf("%q: map %v, expected %v", tt.value, m, tt.expected)
+		}
+	}
+}
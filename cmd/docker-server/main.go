@@ -1,45 +1,133 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
-	"github.com/libopenstorage/openstorage/volume"
 	"github.com/lpabon/openstorage-docker-server/pkg/server"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	mgmtPort   = 2376
-	pluginPort = 2377
-)
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		logrus.Errorf("Invalid configuration: %s", err)
+		os.Exit(2)
+	}
+	if err := cfg.setupLogging(); err != nil {
+		logrus.Errorf("Invalid log settings: %s", err)
+		os.Exit(2)
+	}
 
-var (
-	endpoint   string
-	pluginName string
-	driverName string
-)
+	opts, err := cfg.driverOptions()
+	if err != nil {
+		logrus.Errorf("%s", err)
+		os.Exit(1)
+	}
 
-func init() {
-	flag.StringVar(&endpoint, "e", "localhost:9100", "Endpoint for sdksocket")
-	flag.StringVar(&pluginName, "p", "osd-gateway", "Name for our plugin")
-	flag.StringVar(&driverName, "d", "fake", "Driver we want to use")
+	endpoint := strings.Join(cfg.Endpoints, ",")
+	logrus.Infof("Starting %s with osd sdk: %s (%s driver)", cfg.PluginName, endpoint, cfg.Driver)
+	if err := startAPIs(cfg, endpoint, opts); err != nil {
+		logrus.Errorf("Failed to start server: %s", err)
+		shutdown(cfg)
+		os.Exit(1)
+	}
+
+	// Reload the settings on SIGHUP, stop on SIGTERM or SIGINT
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(cfg)
+				continue
+			}
+			logrus.Infof("Received %v, shutting down", sig)
+			os.Exit(shutdown(cfg))
+		case err := <-server.Errors():
+			logrus.Errorf("Server failed: %s", err)
+			shutdown(cfg)
+			os.Exit(1)
+		}
+	}
 }
 
-func main() {
-	flag.Parse()
+// startAPIs starts the volume plugin API, and the management and cluster APIs
+// unless running as a managed plugin. Docker talks to managed plugins only
+// through the socket set in the plugin config.json, and the other APIs would
+// be exposed on the host network.
+func startAPIs(cfg *config, endpoint string, opts *server.DriverOptions) error {
+	if cfg.Managed {
+		return server.StartVolumePluginAPI(
+			cfg.PluginName, cfg.Driver, endpoint,
+			cfg.Sockets.Plugin,
+			0,
+			opts,
+		)
+	}
 
-	logrus.Infof("Starting %s with osd sdk: %s (%s driver)", pluginName, endpoint, driverName)
 	if err := server.StartPluginAPI(
-		pluginName, driverName, endpoint,
-		volume.DriverAPIBase,
-		volume.PluginAPIBase,
-		uint16(mgmtPort),
-		uint16(pluginPort),
+		cfg.PluginName, cfg.Driver, endpoint,
+		cfg.Sockets.Mgmt,
+		cfg.Sockets.Plugin,
+		uint16(cfg.Ports.Mgmt),
+		uint16(cfg.Ports.Plugin),
+		opts,
 	); err != nil {
-		logrus.Errorf("Failed to start server: %s", err)
-		os.Exit(1)
+		return err
+	}
+	if err := server.StartClusterAPI(
+		cfg.PluginName,
+		cfg.Sockets.Cluster,
+		uint16(cfg.Ports.Cluster),
+	); err != nil {
+		return fmt.Errorf("Failed to start cluster API: %v", err)
 	}
+	return nil
+}
 
-	select {}
+// shutdown stops the servers, waiting for the requests in progress to
+// complete, and returns the exit code.
+func shutdown(cfg *config) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorf("%s", err)
+		return 1
+	}
+	logrus.Infof("Stopped %s", cfg.PluginName)
+	return 0
+}
+
+// reload reads the configuration again and applies the settings which can
+// change while the gateway is running.
+func reload(cfg *config) {
+	logrus.Infof("Reloading configuration")
+	n, err := loadConfig(os.Args[1:])
+	if err != nil {
+		logrus.Errorf("Cannot reload configuration: %s", err)
+		return
+	}
+
+	opts, applied, err := cfg.reload(n)
+	if err != nil {
+		logrus.Errorf("Cannot reload configuration: %s", err)
+		return
+	}
+	if err := server.ReloadPluginOptions(cfg.PluginName, opts); err != nil {
+		logrus.Errorf("Cannot reload configuration: %s", err)
+		return
+	}
+	if !applied {
+		logrus.Warnf("Restart the gateway to apply the changes to the endpoints, " +
+			"plugin name, driver, sockets, ports, state directory, host mounts or TLS settings")
+	}
 }
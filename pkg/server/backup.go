@@ -1,13 +1,167 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/pkg/sched"
 	"github.com/libopenstorage/openstorage/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// cloudBackups returns the SDK cloud backup client. Requests which only read
+// are spread across the SDK endpoints.
+func (vd *volAPI) cloudBackups(
+	ctx context.Context,
+	read bool,
+) (api.OpenStorageCloudBackupClient, error) {
+	getConn := vd.plugin.getConn
+	if read {
+		getConn = vd.plugin.getReadConn
+	}
+	conn, err := getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewOpenStorageCloudBackupClient(conn), nil
+}
+
+// isInvalidName returns true if the SDK server failed the request with
+// volume.ErrInvalidName. Legacy clients expect a conflict for it.
+func isInvalidName(err error) bool {
+	if err == volume.ErrInvalidName {
+		return true
+	}
+	s, ok := status.FromError(err)
+	return ok && strings.Contains(s.Message(), volume.ErrInvalidName.Error())
+}
+
+func backupStatus(s api.SdkCloudBackupStatusType) api.CloudBackupStatusType {
+	return api.CloudBackupStatusType(strings.TrimPrefix(s.String(), "SdkCloudBackupStatusType"))
+}
+
+func sdkBackupStatus(s api.CloudBackupStatusType) api.SdkCloudBackupStatusType {
+	return api.SdkCloudBackupStatusType(api.SdkCloudBackupStatusType_value["SdkCloudBackupStatusType"+string(s)])
+}
+
+func backupOpType(t api.SdkCloudBackupOpType) api.CloudBackupOpType {
+	if t == api.SdkCloudBackupOpType_SdkCloudBackupOpTypeRestoreOp {
+		return api.CloudRestoreOp
+	}
+	return api.CloudBackupOp
+}
+
+func backupTime(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	t, _ := ptypes.Timestamp(ts)
+	return t
+}
+
+// sdkSchedule converts a legacy backup schedule to the SDK schedule
+// intervals. The schedule is parsed by the openstorage scheduler, like the
+// legacy server does.
+func sdkSchedule(schedule string) ([]*api.SdkSchedulePolicyInterval, error) {
+	intervals, err := sched.ParseSchedule(schedule)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid schedule: %v", err)
+	}
+
+	sdkIntervals := make([]*api.SdkSchedulePolicyInterval, 0, len(intervals))
+	for _, interval := range intervals {
+		spec := interval.RetainIntervalSpec()
+		sdkInterval := &api.SdkSchedulePolicyInterval{Retain: int64(spec.Retain)}
+		switch spec.Freq {
+		case sched.PeriodicType:
+			sdkInterval.PeriodType = &api.SdkSchedulePolicyInterval_Periodic{
+				Periodic: &api.SdkSchedulePolicyIntervalPeriodic{
+					Seconds: int64(time.Duration(spec.Period) / time.Second),
+				},
+			}
+		case sched.DailyType:
+			sdkInterval.PeriodType = &api.SdkSchedulePolicyInterval_Daily{
+				Daily: &api.SdkSchedulePolicyIntervalDaily{
+					Hour:   int32(spec.Hour),
+					Minute: int32(spec.Minute),
+				},
+			}
+		case sched.WeeklyType:
+			sdkInterval.PeriodType = &api.SdkSchedulePolicyInterval_Weekly{
+				Weekly: &api.SdkSchedulePolicyIntervalWeekly{
+					Day:    api.SdkTimeWeekday(spec.Weekday),
+					Hour:   int32(spec.Hour),
+					Minute: int32(spec.Minute),
+				},
+			}
+		case sched.MonthlyType:
+			sdkInterval.PeriodType = &api.SdkSchedulePolicyInterval_Monthly{
+				Monthly: &api.SdkSchedulePolicyIntervalMonthly{
+					Day:    int32(spec.Day),
+					Hour:   int32(spec.Hour),
+					Minute: int32(spec.Minute),
+				},
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Invalid schedule: unknown frequency %q", spec.Freq)
+		}
+		sdkIntervals = append(sdkIntervals, sdkInterval)
+	}
+	return sdkIntervals, nil
+}
+
+// legacySchedule converts the SDK schedule intervals to a legacy backup
+// schedule
+func legacySchedule(sdkIntervals []*api.SdkSchedulePolicyInterval) (string, error) {
+	specs := make([]sched.RetainIntervalSpec, 0, len(sdkIntervals))
+	for _, s := range sdkIntervals {
+		var interval sched.Interval
+		if p := s.GetPeriodic(); p != nil {
+			interval = sched.Periodic(time.Duration(p.GetSeconds()) * time.Second)
+		} else if d := s.GetDaily(); d != nil {
+			interval = sched.Daily(int(d.GetHour()), int(d.GetMinute()))
+		} else if w := s.GetWeekly(); w != nil {
+			interval = sched.Weekly(time.Weekday(w.GetDay()), int(w.GetHour()), int(w.GetMinute()))
+		} else if m := s.GetMonthly(); m != nil {
+			interval = sched.Monthly(int(m.GetDay()), int(m.GetHour()), int(m.GetMinute()))
+		} else {
+			return "", status.Errorf(codes.InvalidArgument,
+				"Invalid schedule: unknown interval type %v", s)
+		}
+		specs = append(specs, sched.RetainIntervalSpec{
+			IntervalSpec: interval.Spec(),
+			Retain:       uint32(s.GetRetain()),
+		})
+	}
+	return sched.ScheduleString(specs, nil)
+}
+
+// legacyScheduleInfo converts an SDK backup schedule to a legacy backup
+// schedule
+func legacyScheduleInfo(s *api.SdkCloudBackupScheduleInfo) (api.CloudBackupScheduleInfo, error) {
+	schedule, err := legacySchedule(s.GetSchedules())
+	if err != nil {
+		return api.CloudBackupScheduleInfo{}, err
+	}
+	return api.CloudBackupScheduleInfo{
+		SrcVolumeID:    s.GetSrcVolumeId(),
+		GroupID:        s.GetGroupId(),
+		VolumeIDs:      s.GetVolumeIds(),
+		CredentialUUID: s.GetCredentialId(),
+		Schedule:       schedule,
+		MaxBackups:     uint(s.GetMaxBackups()),
+		Full:           s.GetFull(),
+	}, nil
+}
+
 func (vd *volAPI) cloudBackupCreate(w http.ResponseWriter, r *http.Request) {
 	backupReq := &api.CloudBackupCreateRequest{}
 	method := "cloudBackupCreate"
@@ -17,22 +171,33 @@ func (vd *volAPI) cloudBackupCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, backupReq.VolumeID, w, err)
 		return
 	}
 
-	createResp, err := d.CloudBackupCreate(backupReq)
+	resp, err := backups.Create(ctx, &api.SdkCloudBackupCreateRequest{
+		VolumeId:     backupReq.VolumeID,
+		CredentialId: backupReq.CredentialUUID,
+		Full:         backupReq.Full,
+		TaskId:       backupReq.Name,
+		Labels:       backupReq.Labels,
+	})
 	if err != nil {
-		if err == volume.ErrInvalidName {
+		if isInvalidName(err) {
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
-		vd.sendError(method, backupReq.VolumeID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, backupReq.VolumeID, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(createResp)
+	json.NewEncoder(w).Encode(&api.CloudBackupCreateResponse{
+		Name: resp.GetTaskId(),
+	})
 }
 
 func (vd *volAPI) cloudBackupGroupCreate(w http.ResponseWriter, r *http.Request) {
@@ -44,15 +209,23 @@ func (vd *volAPI) cloudBackupGroupCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, backupGroupReq.GroupID, w, err)
 		return
 	}
 
-	err = d.CloudBackupGroupCreate(backupGroupReq)
+	_, err = backups.GroupCreate(ctx, &api.SdkCloudBackupGroupCreateRequest{
+		GroupId:      backupGroupReq.GroupID,
+		VolumeIds:    backupGroupReq.VolumeIDs,
+		CredentialId: backupGroupReq.CredentialUUID,
+		Full:         backupGroupReq.Full,
+	})
 	if err != nil {
-		vd.sendError(method, backupGroupReq.GroupID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, backupGroupReq.GroupID, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -66,16 +239,20 @@ func (vd *volAPI) cloudBackupRestore(w http.ResponseWriter, r *http.Request) {
 		vd.sendError(method, "", w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	d, err := vd.getVolDriver(r)
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, restoreReq.ID, w, err)
 		return
 	}
 
 	if restoreReq.NodeID != "" {
-		nodeIds, err := vd.nodeIPtoIds([]string{restoreReq.NodeID})
+		nodeIds, err := vd.nodeIPtoIds(ctx, conn, []string{restoreReq.NodeID})
 		if err != nil {
-			vd.sendError(method, restoreReq.ID, w, err.Error(), http.StatusInternalServerError)
+			vd.sendStatusError(method, restoreReq.ID, w, err)
 			return
 		}
 
@@ -86,16 +263,26 @@ func (vd *volAPI) cloudBackupRestore(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	restoreResp, err := d.CloudBackupRestore(restoreReq)
+	resp, err := api.NewOpenStorageCloudBackupClient(conn).Restore(
+		ctx, &api.SdkCloudBackupRestoreRequest{
+			BackupId:          restoreReq.ID,
+			RestoreVolumeName: restoreReq.RestoreVolumeName,
+			CredentialId:      restoreReq.CredentialUUID,
+			NodeId:            restoreReq.NodeID,
+			TaskId:            restoreReq.Name,
+		})
 	if err != nil {
-		if err == volume.ErrInvalidName {
+		if isInvalidName(err) {
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
-		vd.sendError(method, restoreReq.ID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, restoreReq.ID, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(restoreResp)
+	json.NewEncoder(w).Encode(&api.CloudBackupRestoreResponse{
+		RestoreVolumeID: resp.GetRestoreVolumeId(),
+		Name:            resp.GetTaskId(),
+	})
 }
 
 func (vd *volAPI) cloudBackupDelete(w http.ResponseWriter, r *http.Request) {
@@ -107,14 +294,22 @@ func (vd *volAPI) cloudBackupDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, deleteReq.ID, w, err)
 		return
 	}
-	err = d.CloudBackupDelete(deleteReq)
+
+	_, err = backups.Delete(ctx, &api.SdkCloudBackupDeleteRequest{
+		BackupId:     deleteReq.ID,
+		CredentialId: deleteReq.CredentialUUID,
+		Force:        deleteReq.Force,
+	})
 	if err != nil {
-		vd.sendError(method, deleteReq.ID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, deleteReq.ID, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -129,14 +324,21 @@ func (vd *volAPI) cloudBackupDeleteAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, deleteAllReq.SrcVolumeID, w, err)
 		return
 	}
-	err = d.CloudBackupDeleteAll(deleteAllReq)
+
+	_, err = backups.DeleteAll(ctx, &api.SdkCloudBackupDeleteAllRequest{
+		SrcVolumeId:  deleteAllReq.SrcVolumeID,
+		CredentialId: deleteAllReq.CredentialUUID,
+	})
 	if err != nil {
-		vd.sendError(method, deleteAllReq.SrcVolumeID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, deleteAllReq.SrcVolumeID, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -151,44 +353,97 @@ func (vd *volAPI) cloudBackupEnumerate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, true)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 
-	enumerateResp, err := d.CloudBackupEnumerate(enumerateReq)
+	resp, err := backups.EnumerateWithFilters(ctx, &api.SdkCloudBackupEnumerateWithFiltersRequest{
+		SrcVolumeId:       enumerateReq.SrcVolumeID,
+		ClusterId:         enumerateReq.ClusterID,
+		CredentialId:      enumerateReq.CredentialUUID,
+		All:               enumerateReq.All,
+		StatusFilter:      sdkBackupStatus(enumerateReq.StatusFilter),
+		MetadataFilter:    enumerateReq.MetadataFilter,
+		MaxBackups:        enumerateReq.MaxBackups,
+		ContinuationToken: enumerateReq.ContinuationToken,
+		CloudBackupId:     enumerateReq.CloudBackupID,
+	})
 	if err != nil {
-		vd.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 
+	enumerateResp := &api.CloudBackupEnumerateResponse{
+		Backups:           make([]api.CloudBackupInfo, 0, len(resp.GetBackups())),
+		ContinuationToken: resp.GetContinuationToken(),
+	}
+	for _, b := range resp.GetBackups() {
+		enumerateResp.Backups = append(enumerateResp.Backups, api.CloudBackupInfo{
+			ID:            b.GetId(),
+			SrcVolumeID:   b.GetSrcVolumeId(),
+			SrcVolumeName: b.GetSrcVolumeName(),
+			Timestamp:     backupTime(b.GetTimestamp()),
+			Metadata:      b.GetMetadata(),
+			Status:        string(backupStatus(b.GetStatus())),
+		})
+	}
 	json.NewEncoder(w).Encode(enumerateResp)
 }
 
 func (vd *volAPI) cloudBackupStatus(w http.ResponseWriter, r *http.Request) {
 	method := "cloudBackupStatus"
-	backupStatus := &api.CloudBackupStatusRequest{}
+	backupStatusReq := &api.CloudBackupStatusRequest{}
 
-	if err := json.NewDecoder(r.Body).Decode(backupStatus); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(backupStatusReq); err != nil {
 		vd.sendError(method, "", w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, true)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 
-	backupStatusResp, err := d.CloudBackupStatus(backupStatus)
+	resp, err := backups.Status(ctx, &api.SdkCloudBackupStatusRequest{
+		VolumeId: backupStatusReq.SrcVolumeID,
+		Local:    backupStatusReq.Local,
+		TaskId:   backupStatusReq.ID,
+	})
 	if err != nil {
-		if err == volume.ErrInvalidName {
+		if isInvalidName(err) {
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
-		vd.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
-		return
+		vd.sendStatusError(method, "", w, err)
+		return
+	}
+
+	backupStatusResp := &api.CloudBackupStatusResponse{
+		Statuses: make(map[string]api.CloudBackupStatus, len(resp.GetStatuses())),
+	}
+	for name, s := range resp.GetStatuses() {
+		backupStatusResp.Statuses[name] = api.CloudBackupStatus{
+			ID:             s.GetBackupId(),
+			OpType:         backupOpType(s.GetOptype()),
+			Status:         backupStatus(s.GetStatus()),
+			BytesDone:      s.GetBytesDone(),
+			BytesTotal:     s.GetBytesTotal(),
+			EtaSeconds:     s.GetEtaSeconds(),
+			StartTime:      backupTime(s.GetStartTime()),
+			CompletedTime:  backupTime(s.GetCompletedTime()),
+			NodeID:         s.GetNodeId(),
+			SrcVolumeID:    s.GetSrcVolumeId(),
+			CredentialUUID: s.GetCredentialId(),
+		}
 	}
 	json.NewEncoder(w).Encode(backupStatusResp)
 }
@@ -202,20 +457,28 @@ func (vd *volAPI) cloudBackupCatalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, true)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, catalogReq.ID, w, err)
 		return
 	}
 
-	catalog, err := d.CloudBackupCatalog(catalogReq)
+	resp, err := backups.Catalog(ctx, &api.SdkCloudBackupCatalogRequest{
+		BackupId:     catalogReq.ID,
+		CredentialId: catalogReq.CredentialUUID,
+	})
 	if err != nil {
-		vd.sendError(method, catalogReq.ID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, catalogReq.ID, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(catalog)
-
+	json.NewEncoder(w).Encode(&api.CloudBackupCatalogResponse{
+		Contents: resp.GetContents(),
+	})
 }
+
 func (vd *volAPI) cloudBackupHistory(w http.ResponseWriter, r *http.Request) {
 	method := "cloudBackupHistory"
 	historyReq := &api.CloudBackupHistoryRequest{}
@@ -225,17 +488,33 @@ func (vd *volAPI) cloudBackupHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, true)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, historyReq.SrcVolumeID, w, err)
 		return
 	}
 
-	history, err := d.CloudBackupHistory(historyReq)
+	resp, err := backups.History(ctx, &api.SdkCloudBackupHistoryRequest{
+		SrcVolumeId: historyReq.SrcVolumeID,
+	})
 	if err != nil {
-		vd.sendError(method, historyReq.SrcVolumeID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, historyReq.SrcVolumeID, w, err)
 		return
 	}
+
+	history := &api.CloudBackupHistoryResponse{
+		HistoryList: make([]api.CloudBackupHistoryItem, 0, len(resp.GetHistoryList())),
+	}
+	for _, h := range resp.GetHistoryList() {
+		history.HistoryList = append(history.HistoryList, api.CloudBackupHistoryItem{
+			SrcVolumeID: h.GetSrcVolumeId(),
+			Timestamp:   backupTime(h.GetTimestamp()),
+			Status:      string(backupStatus(h.GetStatus())),
+		})
+	}
 	json.NewEncoder(w).Encode(history)
 }
 
@@ -247,15 +526,35 @@ func (vd *volAPI) cloudBackupStateChange(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	var state api.SdkCloudBackupRequestedState
+	switch stateChangeReq.RequestedState {
+	case api.CloudBackupRequestedStatePause:
+		state = api.SdkCloudBackupRequestedState_SdkCloudBackupRequestedStatePause
+	case api.CloudBackupRequestedStateResume:
+		state = api.SdkCloudBackupRequestedState_SdkCloudBackupRequestedStateResume
+	case api.CloudBackupRequestedStateStop:
+		state = api.SdkCloudBackupRequestedState_SdkCloudBackupRequestedStateStop
+	default:
+		vd.sendError(method, "", w, "Invalid requested state "+stateChangeReq.RequestedState,
+			http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 
-	err = d.CloudBackupStateChange(stateChangeReq)
+	_, err = backups.StateChange(ctx, &api.SdkCloudBackupStateChangeRequest{
+		TaskId:         stateChangeReq.Name,
+		RequestedState: state,
+	})
 	if err != nil {
-		vd.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -269,18 +568,37 @@ func (vd *volAPI) cloudBackupSchedCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	schedules, err := sdkSchedule(backupSchedReq.Schedule)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, backupSchedReq.SrcVolumeID, w, err)
 		return
 	}
 
-	backupSchedResp, err := d.CloudBackupSchedCreate(backupSchedReq)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		vd.sendError(method, backupSchedReq.SrcVolumeID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, backupSchedReq.SrcVolumeID, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(backupSchedResp)
+
+	resp, err := backups.SchedCreate(ctx, &api.SdkCloudBackupSchedCreateRequest{
+		CloudSchedInfo: &api.SdkCloudBackupScheduleInfo{
+			SrcVolumeId:  backupSchedReq.SrcVolumeID,
+			CredentialId: backupSchedReq.CredentialUUID,
+			Schedules:    schedules,
+			MaxBackups:   uint64(backupSchedReq.MaxBackups),
+			Full:         backupSchedReq.Full,
+		},
+	})
+	if err != nil {
+		vd.sendStatusError(method, backupSchedReq.SrcVolumeID, w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&api.CloudBackupSchedCreateResponse{
+		UUID: resp.GetBackupScheduleId(),
+	})
 }
 
 func (vd *volAPI) cloudBackupGroupSchedCreate(w http.ResponseWriter, r *http.Request) {
@@ -291,18 +609,38 @@ func (vd *volAPI) cloudBackupGroupSchedCreate(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	schedules, err := sdkSchedule(backupGroupSchedReq.Schedule)
+	if err != nil {
+		vd.sendStatusError(method, backupGroupSchedReq.GroupID, w, err)
+		return
+	}
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, backupGroupSchedReq.GroupID, w, err)
 		return
 	}
 
-	backupSchedResp, err := d.CloudBackupGroupSchedCreate(backupGroupSchedReq)
+	resp, err := backups.SchedCreate(ctx, &api.SdkCloudBackupSchedCreateRequest{
+		CloudSchedInfo: &api.SdkCloudBackupScheduleInfo{
+			GroupId:      backupGroupSchedReq.GroupID,
+			VolumeIds:    backupGroupSchedReq.VolumeIDs,
+			CredentialId: backupGroupSchedReq.CredentialUUID,
+			Schedules:    schedules,
+			MaxBackups:   uint64(backupGroupSchedReq.MaxBackups),
+			Full:         backupGroupSchedReq.Full,
+		},
+	})
 	if err != nil {
-		vd.sendError(method, backupGroupSchedReq.GroupID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, backupGroupSchedReq.GroupID, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(backupSchedResp)
+	json.NewEncoder(w).Encode(&api.CloudBackupSchedCreateResponse{
+		UUID: resp.GetBackupScheduleId(),
+	})
 }
 
 func (vd *volAPI) cloudBackupSchedDelete(w http.ResponseWriter, r *http.Request) {
@@ -313,15 +651,20 @@ func (vd *volAPI) cloudBackupSchedDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, false)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, deleteReq.UUID, w, err)
 		return
 	}
 
-	err = d.CloudBackupSchedDelete(deleteReq)
+	_, err = backups.SchedDelete(ctx, &api.SdkCloudBackupSchedDeleteRequest{
+		BackupScheduleId: deleteReq.UUID,
+	})
 	if err != nil {
-		vd.sendError(method, deleteReq.UUID, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, deleteReq.UUID, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -329,15 +672,32 @@ func (vd *volAPI) cloudBackupSchedDelete(w http.ResponseWriter, r *http.Request)
 
 func (vd *volAPI) cloudBackupSchedEnumerate(w http.ResponseWriter, r *http.Request) {
 	method := "cloudBackupSchedEnumerate"
-	d, err := vd.getVolDriver(r)
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	backups, err := vd.cloudBackups(ctx, true)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
-	schedules, err := d.CloudBackupSchedEnumerate()
+
+	resp, err := backups.SchedEnumerate(ctx, &api.SdkCloudBackupSchedEnumerateRequest{})
 	if err != nil {
-		vd.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
+
+	schedules := &api.CloudBackupSchedEnumerateResponse{
+		Schedules: make(map[string]api.CloudBackupScheduleInfo, len(resp.GetCloudSchedList())),
+	}
+	for id, s := range resp.GetCloudSchedList() {
+		info, err := legacyScheduleInfo(s)
+		if err != nil {
+			vd.sendStatusError(method, id, w, err)
+			return
+		}
+		schedules.Schedules[id] = info
+	}
 	json.NewEncoder(w).Encode(schedules)
 }
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSdkSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		check    func(intervals []*api.SdkSchedulePolicyInterval) bool
+		code     codes.Code
+	}{
+		{
+			name:     "periodic",
+			schedule: "- freq: periodic\n  period: 3600000000000\n  retain: 5\n",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 1 && i[0].GetPeriodic().GetSeconds() == 3600 &&
+					i[0].GetRetain() == 5
+			},
+		},
+		{
+			name:     "daily",
+			schedule: "- freq: daily\n  hour: 2\n  minute: 30\n",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 1 && i[0].GetDaily().GetHour() == 2 &&
+					i[0].GetDaily().GetMinute() == 30
+			},
+		},
+		{
+			name:     "weekly",
+			schedule: "- freq: weekly\n  weekday: 1\n  hour: 3\n",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 1 && i[0].GetWeekly().GetDay() == api.SdkTimeWeekday(1) &&
+					i[0].GetWeekly().GetHour() == 3
+			},
+		},
+		{
+			name:     "monthly",
+			schedule: "- freq: monthly\n  day: 15\n  hour: 4\n  minute: 5\n",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 1 && i[0].GetMonthly().GetDay() == 15 &&
+					i[0].GetMonthly().GetHour() == 4 && i[0].GetMonthly().GetMinute() == 5
+			},
+		},
+		{
+			name:     "several intervals",
+			schedule: "- freq: daily\n  hour: 1\n- freq: monthly\n  day: 1\n",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 2 && i[0].GetDaily() != nil && i[1].GetMonthly() != nil
+			},
+		},
+		{
+			name:     "empty",
+			schedule: "",
+			check: func(i []*api.SdkSchedulePolicyInterval) bool {
+				return len(i) == 0
+			},
+		},
+		{
+			name:     "unknown frequency",
+			schedule: "- freq: hourly\n",
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:     "invalid yaml",
+			schedule: "freq: daily",
+			code:     codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		intervals, err := sdkSchedule(tt.schedule)
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%s: error %v, expected code %v", tt.name, err, tt.code)
+			continue
+		}
+		if err == nil && !tt.check(intervals) {
+			t.Errorf("%s: unexpected intervals %v", tt.name, intervals)
+		}
+	}
+}
+
+func TestLegacySchedule(t *testing.T) {
+	tests := []string{
+		"- freq: periodic\n  period: 3600000000000\n  retain: 5\n",
+		"- freq: daily\n  hour: 2\n  minute: 30\n",
+		"- freq: weekly\n  weekday: 1\n  hour: 3\n",
+		"- freq: monthly\n  day: 15\n  hour: 4\n  minute: 5\n  retain: 2\n",
+		"- freq: daily\n  hour: 1\n- freq: monthly\n  day: 1\n",
+	}
+
+	for _, schedule := range tests {
+		intervals, err := sdkSchedule(schedule)
+		if err != nil {
+			t.Errorf("%q: sdkSchedule: %v", schedule, err)
+			continue
+		}
+		legacy, err := legacySchedule(intervals)
+		if err != nil {
+			t.Errorf("%q: legacySchedule: %v", schedule, err)
+			continue
+		}
+		if legacy != schedule {
+			t.Errorf("schedule %q converted back to %q", schedule, legacy)
+		}
+	}
+}
+
+func TestLegacyScheduleUnknownInterval(t *testing.T) {
+	intervals := []*api.SdkSchedulePolicyInterval{{Retain: 1}}
+	if _, err := legacySchedule(intervals); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("error %v, expected code %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestLegacyScheduleInfo(t *testing.T) {
+	intervals, err := sdkSchedule("- freq: daily\n  hour: 2\n")
+	if err != nil {
+		t.Fatalf("sdkSchedule: %v", err)
+	}
+	info, err := legacyScheduleInfo(&api.SdkCloudBackupScheduleInfo{
+		GroupId:      "group",
+		VolumeIds:    []string{"1", "2"},
+		CredentialId: "cred",
+		Schedules:    intervals,
+		MaxBackups:   3,
+		Full:         true,
+	})
+	if err != nil {
+		t.Fatalf("legacyScheduleInfo: %v", err)
+	}
+	expected := api.CloudBackupScheduleInfo{
+		GroupID:        "group",
+		VolumeIDs:      []string{"1", "2"},
+		CredentialUUID: "cred",
+		Schedule:       "- freq: daily\n  hour: 2\n",
+		MaxBackups:     3,
+		Full:           true,
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("schedule %+v, expected %+v", info, expected)
+	}
+}
+
+func TestBackupStatus(t *testing.T) {
+	tests := []struct {
+		sdk    api.SdkCloudBackupStatusType
+		legacy api.CloudBackupStatusType
+	}{
+		{api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeDone, api.CloudBackupStatusDone},
+		{api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeFailed, api.CloudBackupStatusFailed},
+		{api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeAborted, api.CloudBackupStatusAborted},
+		{api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeActive, api.CloudBackupStatusActive},
+	}
+
+	for _, tt := range tests {
+		if legacy := backupStatus(tt.sdk); legacy != tt.legacy {
+			t.Errorf("%v: legacy status %q, expected %q", tt.sdk, legacy, tt.legacy)
+		}
+		if sdk := sdkBackupStatus(tt.legacy); sdk != tt.sdk {
+			t.Errorf("%q: SDK status %v, expected %v", tt.legacy, sdk, tt.sdk)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -19,16 +20,21 @@ const (
 	nodeNotOkMsg = "Node status not OK"
 )
 
+// clusterApi serves the cluster REST API. The cluster, node and version
+// handlers are translated to the SDK, using the connections of the volume
+// plugin.
 type clusterApi struct {
 	restBase
+	plugin *driver
 }
 
-func newClusterAPI() restServer {
+func newClusterAPI(plugin *driver) restServer {
 	return &clusterApi{
 		restBase: restBase{
 			version: cluster.APIVersion,
 			name:    "Cluster API",
 		},
+		plugin: plugin,
 	}
 }
 
@@ -36,6 +42,67 @@ func (c *clusterApi) String() string {
 	return c.name
 }
 
+// requestContext returns the context used for the SDK calls of a request.
+// The token is taken from the Authorization header, or from the default
+// token file of the plugin.
+func (c *clusterApi) requestContext(
+	r *http.Request,
+	method string,
+) (context.Context, context.CancelFunc) {
+	return c.plugin.requestContext(r, method, "", bearerOptions(r))
+}
+
+// legacyNode converts a node returned by the SDK to the node of the cluster
+// API
+func legacyNode(n *api.StorageNode) api.Node {
+	node := api.Node{
+		Id:                n.GetId(),
+		SchedulerNodeName: n.GetSchedulerNodeName(),
+		Cpu:               n.GetCpu(),
+		CpuCores:          int(n.GetCpuCores()),
+		MemTotal:          n.GetMemTotal(),
+		MemUsed:           n.GetMemUsed(),
+		MemFree:           n.GetMemFree(),
+		Avgload:           int(n.GetAvgLoad()),
+		Status:            n.GetStatus(),
+		Disks:             make(map[string]api.StorageResource, len(n.GetDisks())),
+		Pools:             make([]api.StoragePool, 0, len(n.GetPools())),
+		MgmtIp:            n.GetMgmtIp(),
+		DataIp:            n.GetDataIp(),
+		Hostname:          n.GetHostname(),
+		NodeLabels:        n.GetNodeLabels(),
+	}
+	for name, disk := range n.GetDisks() {
+		if disk != nil {
+			node.Disks[name] = *disk
+		}
+	}
+	for _, pool := range n.GetPools() {
+		if pool != nil {
+			node.Pools = append(node.Pools, *pool)
+		}
+	}
+	return node
+}
+
+// enumerateNodes returns all the nodes of the cluster
+func enumerateNodes(ctx context.Context, nodes api.OpenStorageNodeClient) ([]api.Node, error) {
+	resp, err := nodes.Enumerate(ctx, &api.SdkNodeEnumerateRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	legacyNodes := make([]api.Node, 0, len(resp.GetNodeIds()))
+	for _, id := range resp.GetNodeIds() {
+		node, err := nodes.Inspect(ctx, &api.SdkNodeInspectRequest{NodeId: id})
+		if err != nil {
+			return nil, err
+		}
+		legacyNodes = append(legacyNodes, legacyNode(node.GetNode()))
+	}
+	return legacyNodes, nil
+}
+
 // swagger:operation GET /cluster/enumerate cluster enumerateCluster
 //
 // Lists cluster Nodes.
@@ -54,41 +121,47 @@ func (c *clusterApi) String() string {
 //            $ref: '#/definitions/Cluster'
 func (c *clusterApi) enumerate(w http.ResponseWriter, r *http.Request) {
 	method := "enumerate"
-	inst, err := clustermanager.Inst()
+
+	ctx, cancel := c.requestContext(r, method)
+	defer cancel()
+
+	// The node answering for the cluster is the first available endpoint,
+	// the local node of the gateway unless it is down
+	conn, err := c.plugin.getConn(ctx)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
-	cluster, err := inst.Enumerate()
+
+	current, err := api.NewOpenStorageClusterClient(conn).InspectCurrent(
+		ctx, &api.SdkClusterInspectCurrentRequest{})
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
-	json.NewEncoder(w).Encode(cluster)
-}
-
-func (c *clusterApi) setSize(w http.ResponseWriter, r *http.Request) {
-	method := "set size"
-	inst, err := clustermanager.Inst()
+	nodes := api.NewOpenStorageNodeClient(conn)
+	local, err := nodes.InspectCurrent(ctx, &api.SdkNodeInspectCurrentRequest{})
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
-
-	params := r.URL.Query()
-
-	size := params["size"]
-	if size == nil {
-		c.sendError(c.name, method, w, "Missing size param", http.StatusBadRequest)
+	clusterNodes, err := enumerateNodes(ctx, nodes)
+	if err != nil {
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
-	sz, _ := strconv.Atoi(size[0])
-
-	err = inst.SetSize(sz)
+	json.NewEncoder(w).Encode(&api.Cluster{
+		Id:     current.GetCluster().GetId(),
+		NodeId: local.GetNode().GetId(),
+		Status: current.GetCluster().GetStatus(),
+		Nodes:  clusterNodes,
+	})
+}
 
-	clusterResponse := &api.ClusterResponse{Error: err.Error()}
-	json.NewEncoder(w).Encode(clusterResponse)
+func (c *clusterApi) setSize(w http.ResponseWriter, r *http.Request) {
+	method := "set size"
+	c.sendNotImplemented(w, method)
 }
 
 // swagger:operation GET /cluster/inspect/{id} cluster inspectNode
@@ -113,11 +186,6 @@ func (c *clusterApi) setSize(w http.ResponseWriter, r *http.Request) {
 //       $ref: '#/definitions/Node'
 func (c *clusterApi) inspect(w http.ResponseWriter, r *http.Request) {
 	method := "inspect"
-	inst, err := clustermanager.Inst()
-	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 
 	vars := mux.Vars(r)
 	nodeID, ok := vars["id"]
@@ -127,11 +195,23 @@ func (c *clusterApi) inspect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if nodeStats, err := inst.Inspect(nodeID); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
-	} else {
-		json.NewEncoder(w).Encode(nodeStats)
+	ctx, cancel := c.requestContext(r, method)
+	defer cancel()
+
+	conn, err := c.plugin.getReadConn(ctx)
+	if err != nil {
+		c.sendStatusError(c.name, method, w, err)
+		return
+	}
+
+	resp, err := api.NewOpenStorageNodeClient(conn).Inspect(ctx, &api.SdkNodeInspectRequest{
+		NodeId: nodeID,
+	})
+	if err != nil {
+		c.sendStatusError(c.name, method, w, err)
+		return
 	}
+	json.NewEncoder(w).Encode(legacyNode(resp.GetNode()))
 }
 
 func (c *clusterApi) enableGossip(w http.ResponseWriter, r *http.Request) {
@@ -139,7 +219,7 @@ func (c *clusterApi) enableGossip(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -154,7 +234,7 @@ func (c *clusterApi) disableGossip(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -169,7 +249,7 @@ func (c *clusterApi) gossipState(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -199,7 +279,7 @@ func (c *clusterApi) getNodeIdFromIp(w http.ResponseWriter, r *http.Request) {
 	method := "getnodeidfromip"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -212,7 +292,7 @@ func (c *clusterApi) getNodeIdFromIp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if nodeID, err := inst.GetNodeIdFromIp(nodeIP); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 	} else {
 		json.NewEncoder(w).Encode(nodeID)
 	}
@@ -233,35 +313,42 @@ func (c *clusterApi) getNodeIdFromIp(w http.ResponseWriter, r *http.Request) {
 func (c *clusterApi) status(w http.ResponseWriter, r *http.Request) {
 	method := "status"
 
-	inst, err := clustermanager.Inst()
+	ctx, cancel := c.requestContext(r, method)
+	defer cancel()
 
+	conn, err := c.plugin.getReadConn(ctx)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
-	cluster, err := inst.Enumerate()
-
+	resp, err := api.NewOpenStorageClusterClient(conn).InspectCurrent(
+		ctx, &api.SdkClusterInspectCurrentRequest{})
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(cluster.Status)
+	json.NewEncoder(w).Encode(resp.GetCluster().GetStatus())
 }
 
-func nodeStatusIntl() (api.Status, error) {
-	inst, err := clustermanager.Inst()
+// localNodeStatus returns the status of the local node of the gateway
+func (c *clusterApi) localNodeStatus(r *http.Request, method string) (api.Status, error) {
+	ctx, cancel := c.requestContext(r, method)
+	defer cancel()
+
+	conn, err := c.plugin.getLocalConn(ctx)
 	if err != nil {
 		return api.Status_STATUS_NONE, err
 	}
 
-	resp, err := inst.NodeStatus()
+	resp, err := api.NewOpenStorageNodeClient(conn).InspectCurrent(
+		ctx, &api.SdkNodeInspectCurrentRequest{})
 	if err != nil {
 		return api.Status_STATUS_NONE, err
 	}
 
-	return resp, nil
+	return resp.GetNode().GetStatus(), nil
 }
 
 // swagger:operation GET /cluster/nodestatus node nodeStatus
@@ -279,9 +366,9 @@ func nodeStatusIntl() (api.Status, error) {
 func (c *clusterApi) nodeStatus(w http.ResponseWriter, r *http.Request) {
 	method := "nodeStatus"
 
-	st, err := nodeStatusIntl()
+	st, err := c.localNodeStatus(r, method)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -303,9 +390,9 @@ func (c *clusterApi) nodeStatus(w http.ResponseWriter, r *http.Request) {
 func (c *clusterApi) nodeHealth(w http.ResponseWriter, r *http.Request) {
 	method := "nodeHealth"
 
-	st, err := nodeStatusIntl()
+	st, err := c.localNodeStatus(r, method)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusServiceUnavailable)
+		c.sendError(c.name, method, w, translateError(err).Error(), http.StatusServiceUnavailable)
 		return
 	}
 
@@ -345,19 +432,29 @@ func (c *clusterApi) peerStatus(w http.ResponseWriter, r *http.Request) {
 		c.sendError(c.name, method, w, "Missing id param", http.StatusBadRequest)
 		return
 	}
-	inst, err := clustermanager.Inst()
+
+	ctx, cancel := c.requestContext(r, method)
+	defer cancel()
+
+	conn, err := c.plugin.getReadConn(ctx)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
-	resp, err := inst.PeerStatus(listenerName[0])
+
+	// The SDK does not report the peers seen by each listener, so the
+	// status of the nodes is the one known to the SDK server.
+	nodes, err := enumerateNodes(ctx, api.NewOpenStorageNodeClient(conn))
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
+	resp := make(map[string]api.Status, len(nodes))
+	for _, node := range nodes {
+		resp[node.Id] = node.Status
+	}
 
 	json.NewEncoder(w).Encode(resp)
-
 }
 
 // swagger:operation DELETE /cluster/{id} cluster deleteNode
@@ -408,7 +505,7 @@ func (c *clusterApi) delete(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -537,13 +634,13 @@ func (c *clusterApi) enumerateAlerts(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	alerts, err := inst.EnumerateAlerts(tS, tE, resourceType)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(alerts)
@@ -588,13 +685,13 @@ func (c *clusterApi) eraseAlert(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.EraseAlert(resourceType, alertId)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode("Successfully erased Alert")
@@ -682,19 +779,19 @@ func (c *clusterApi) createPair(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	resp, err := inst.CreatePair(pairRequest)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 }
@@ -710,19 +807,19 @@ func (c *clusterApi) processPair(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	resp, err := inst.ProcessPairRequest(processPairRequest)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 }
@@ -732,18 +829,18 @@ func (c *clusterApi) enumeratePairs(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	resp, err := inst.EnumeratePairs()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 }
@@ -760,13 +857,13 @@ func (c *clusterApi) getPair(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	resp, err := inst.GetPair(id)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -785,13 +882,13 @@ func (c *clusterApi) refreshPair(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.RefreshPair(id)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -810,13 +907,13 @@ func (c *clusterApi) deletePair(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.DeletePair(id)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -840,13 +937,13 @@ func (c *clusterApi) getPairToken(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	resp, err := inst.GetPairToken(reset)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
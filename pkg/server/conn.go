@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// sdkReadyTimeout is how long a request waits for the connection to
+	// the SDK server to be ready.
+	sdkReadyTimeout = 10 * time.Second
+	// sdkFailureTimeout is how long a connection can fail before it is
+	// replaced by a new one.
+	sdkFailureTimeout = 30 * time.Second
+	// sdkMinBackoff and sdkMaxBackoff bound the delay between replacing
+	// connections to the SDK server.
+	sdkMinBackoff = 1 * time.Second
+	sdkMaxBackoff = 1 * time.Minute
+	// sdkMaxRetryDelay bounds the delay between the reconnect attempts of
+	// a single connection.
+	sdkMaxRetryDelay = 5 * time.Second
+
+	// sdkKeepaliveTime is how long a connection with requests in progress
+	// can be idle before it is checked. The gRPC servers close connections
+	// pinging more often than every 5 minutes, or pinging without requests
+	// in progress, unless their enforcement policy is relaxed.
+	sdkKeepaliveTime    = 5 * time.Minute
+	sdkKeepaliveTimeout = 10 * time.Second
+
+	unixPrefix = "unix://"
+)
+
+// connManager provides a connection to the SDK server which can be used by
+// concurrent requests. The state of the connection is watched, and if it
+// fails for too long or is shut down, it is replaced by a new connection.
+// New connections are dialed with an exponential backoff.
+type connManager struct {
+	lock        sync.Mutex
+	endpoint    string
+	dialOptions []grpc.DialOption
+	conn        *grpc.ClientConn
+	backoff     time.Duration
+	nextDial    time.Time
+	closed      bool
+}
+
+// isUnixEndpoint returns true if the endpoint is a unix domain socket
+func isUnixEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, unixPrefix) || strings.HasPrefix(endpoint, "/")
+}
+
+// isLoopbackEndpoint returns true if the TCP endpoint is on this host
+func isLoopbackEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sendsPlaintext returns true if tokens are sent in plaintext to an endpoint
+// which is not on this host.
+func sendsPlaintext(endpoint string, creds credentials.TransportCredentials) bool {
+	return creds == nil && !isUnixEndpoint(endpoint) && !isLoopbackEndpoint(endpoint)
+}
+
+// checkPlaintext returns an error if tokens would be sent in plaintext to an
+// endpoint which is not on this host, unless insecure is set.
+func checkPlaintext(endpoint string, creds credentials.TransportCredentials, insecure bool) error {
+	if !sendsPlaintext(endpoint, creds) || insecure {
+		return nil
+	}
+	return fmt.Errorf("TLS is not configured for SDK endpoint %s, which is not on this host. "+
+		"Configure TLS or allow sending tokens in plaintext with -insecure", endpoint)
+}
+
+// newConnManager returns a manager for the connection to endpoint. If creds
+// is nil or endpoint is a unix domain socket, the connection is not
+// encrypted.
+func newConnManager(endpoint string, creds credentials.TransportCredentials) *connManager {
+	dialOptions := []grpc.DialOption{
+		grpc.WithBackoffMaxDelay(sdkMaxRetryDelay),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    sdkKeepaliveTime,
+			Timeout: sdkKeepaliveTimeout,
+		}),
+	}
+
+	// Unix domain sockets need their own dialer
+	if isUnixEndpoint(endpoint) {
+		socket := strings.TrimPrefix(endpoint, unixPrefix)
+		dialOptions = append(dialOptions,
+			grpc.WithInsecure(),
+			grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+				return net.DialTimeout("unix", socket, timeout)
+			}))
+	} else if creds != nil {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+
+	return &connManager{
+		endpoint:    endpoint,
+		dialOptions: dialOptions,
+		backoff:     sdkMinBackoff,
+	}
+}
+
+// Conn returns the connection to the SDK server once it is ready to be used.
+// It waits at most sdkReadyTimeout for the connection.
+func (c *connManager) Conn(ctx context.Context) (*grpc.ClientConn, error) {
+	conn, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, sdkReadyTimeout)
+	defer cancel()
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return conn, nil
+		case connectivity.Shutdown:
+			return nil, status.Errorf(codes.Unavailable, "Connection to SDK server %s was closed", c.endpoint)
+		}
+		if !conn.WaitForStateChange(readyCtx, state) {
+			if err := ctx.Err(); err != nil {
+				return nil, contextError(err)
+			}
+			return nil, status.Errorf(codes.Unavailable, "SDK server %s is not available (%v): %v",
+				c.endpoint, state, readyCtx.Err())
+		}
+	}
+}
+
+// Close closes the connection to the SDK server
+func (c *connManager) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// current returns the current connection, dialing a new one if needed
+func (c *connManager) current() (*grpc.ClientConn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil, status.Errorf(codes.Unavailable, "Connection to SDK server %s is closed", c.endpoint)
+	}
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if wait := time.Until(c.nextDial); wait > 0 {
+		return nil, status.Errorf(codes.Unavailable, "SDK server %s is not available, reconnecting in %v",
+			c.endpoint, wait.Round(time.Second))
+	}
+
+	conn, err := grpc.Dial(c.endpoint, c.dialOptions...)
+	if err != nil {
+		c.delayNextDial()
+		return nil, status.Errorf(codes.Unavailable, "Failed to connect to gRPC handler: %v", err)
+	}
+	logrus.Infof("Connecting to SDK server %s", c.endpoint)
+	c.conn = conn
+	go c.watch(conn)
+	return conn, nil
+}
+
+// delayNextDial doubles the delay before dialing again. Must be called with
+// the lock held.
+func (c *connManager) delayNextDial() {
+	c.nextDial = time.Now().Add(c.backoff)
+	c.backoff *= 2
+	if c.backoff > sdkMaxBackoff {
+		c.backoff = sdkMaxBackoff
+	}
+}
+
+// replace closes conn so that the next request dials a new connection
+func (c *connManager) replace(conn *grpc.ClientConn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+	logrus.Warnf("Replacing connection to SDK server %s", c.endpoint)
+	c.conn.Close()
+	c.conn = nil
+	c.delayNextDial()
+}
+
+// watch follows the state of the connection until it is replaced or closed
+func (c *connManager) watch(conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if state == connectivity.TransientFailure {
+			ctx, cancel = context.WithTimeout(ctx, sdkFailureTimeout)
+		}
+		changed := conn.WaitForStateChange(ctx, state)
+		cancel()
+
+		if !changed {
+			// The connection has been failing for too long
+			c.replace(conn)
+			return
+		}
+
+		state = conn.GetState()
+		logrus.Debugf("Connection to SDK server %s is %v", c.endpoint, state)
+		switch state {
+		case connectivity.Ready:
+			c.lock.Lock()
+			c.backoff = sdkMinBackoff
+			c.lock.Unlock()
+		case connectivity.Shutdown:
+			c.replace(conn)
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+func TestDelayNextDial(t *testing.T) {
+	c := newConnManager("localhost:9100", nil)
+
+	// The delay doubles from sdkMinBackoff up to sdkMaxBackoff
+	expected := []time.Duration{
+		1 * time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		32 * time.Second,
+		sdkMaxBackoff,
+		sdkMaxBackoff,
+	}
+	for i, delay := range expected {
+		before := time.Now()
+		c.delayNextDial()
+		if wait := c.nextDial.Sub(before); wait < delay || wait > delay+time.Second {
+			t.Errorf("dial %d: waiting %v, expected %v", i, wait, delay)
+		}
+		if c.backoff > sdkMaxBackoff {
+			t.Errorf("dial %d: backoff %v above %v", i, c.backoff, sdkMaxBackoff)
+		}
+	}
+
+	// Dialing is refused until the delay has passed
+	if _, err := c.current(); err == nil {
+		t.Errorf("dialed before the end of the backoff")
+	}
+}
+
+func TestConnManagerClosed(t *testing.T) {
+	c := newConnManager("localhost:9100", nil)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := c.Conn(context.Background()); err == nil {
+		t.Errorf("connection returned after Close")
+	}
+}
+
+func TestIsUnixEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		unix     bool
+	}{
+		{endpoint: "unix:///run/osd.sock", unix: true},
+		{endpoint: "/run/osd.sock", unix: true},
+		{endpoint: "localhost:9100"},
+		{endpoint: "10.0.0.2:9100"},
+	}
+
+	for _, tt := range tests {
+		if unix := isUnixEndpoint(tt.endpoint); unix != tt.unix {
+			t.Errorf("%s: unix %v, expected %v", tt.endpoint, unix, tt.unix)
+		}
+	}
+}
+
+func TestIsLoopbackEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		loopback bool
+	}{
+		{endpoint: "localhost:9100", loopback: true},
+		{endpoint: "127.0.0.1:9100", loopback: true},
+		{endpoint: "127.1.2.3:9100", loopback: true},
+		{endpoint: "[::1]:9100", loopback: true},
+		{endpoint: "localhost", loopback: true},
+		{endpoint: "10.0.0.2:9100"},
+		{endpoint: "[fe80::1]:9100"},
+		{endpoint: "osd.example.com:9100"},
+		{endpoint: "localhost.example.com:9100"},
+	}
+
+	for _, tt := range tests {
+		if loopback := isLoopbackEndpoint(tt.endpoint); loopback != tt.loopback {
+			t.Errorf("%s: loopback %v, expected %v", tt.endpoint, loopback, tt.loopback)
+		}
+	}
+}
+
+func TestCheckPlaintext(t *testing.T) {
+	creds := credentials.NewTLS(&tls.Config{})
+	tests := []struct {
+		endpoint string
+		creds    credentials.TransportCredentials
+		insecure bool
+		refused  bool
+	}{
+		{endpoint: "10.0.0.2:9100", refused: true},
+		{endpoint: "10.0.0.2:9100", insecure: true},
+		{endpoint: "10.0.0.2:9100", creds: creds},
+		{endpoint: "osd.example.com:9100", refused: true},
+		{endpoint: "localhost:9100"},
+		{endpoint: "127.0.0.1:9100"},
+		{endpoint: "unix:///run/osd.sock"},
+		{endpoint: "/run/osd.sock"},
+	}
+
+	for _, tt := range tests {
+		err := checkPlaintext(tt.endpoint, tt.creds, tt.insecure)
+		if refused := err != nil; refused != tt.refused {
+			t.Errorf("%s with TLS %v and insecure %v: error %v, expected refused %v",
+				tt.endpoint, tt.creds != nil, tt.insecure, err, tt.refused)
+		}
+	}
+}
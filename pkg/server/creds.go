@@ -2,26 +2,170 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/libopenstorage/openstorage/api"
 )
 
+const (
+	// credName is the input parameter with the name of the credentials
+	credName = "name"
+	// redacted replaces the secrets in responses and logs
+	redacted = "********"
+)
+
+// credSecrets are the input parameters which are never sent back or logged
+var credSecrets = map[string]bool{
+	api.OptCredAccessKey:       true,
+	api.OptCredSecretKey:       true,
+	api.OptCredAzureAccountKey: true,
+	api.OptCredGoogleJsonKey:   true,
+	api.OptCredEncrKey:         true,
+}
+
+// credRequired are the input parameters required for each type of credentials
+var credRequired = map[string][]string{
+	"s3":     {api.OptCredAccessKey, api.OptCredSecretKey, api.OptCredEndpoint, api.OptCredRegion},
+	"azure":  {api.OptCredAzureAccountName, api.OptCredAzureAccountKey},
+	"google": {api.OptCredGoogleProjectID, api.OptCredGoogleJsonKey},
+}
+
+// redactCreds returns a copy of params with the secrets redacted
+func redactCreds(params map[string]string) map[string]string {
+	out := make(map[string]string, len(params))
+	for k, v := range params {
+		if credSecrets[k] && v != "" {
+			v = redacted
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// sdkCredCreateRequest converts the legacy input parameters to the SDK
+// request, returning an error naming the missing or invalid parameters.
+func sdkCredCreateRequest(params map[string]string) (*api.SdkCredentialCreateRequest, error) {
+	credType := params[api.OptCredType]
+	required, ok := credRequired[credType]
+	if !ok {
+		types := make([]string, 0, len(credRequired))
+		for t := range credRequired {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("Invalid %s %q, must be one of: %s",
+			api.OptCredType, credType, strings.Join(types, ", "))
+	}
+
+	// The SDK server requires a name for all types of credentials
+	missing := make([]string, 0)
+	for _, k := range append([]string{credName}, required...) {
+		if params[k] == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("Missing required fields for %s credentials: %s",
+			credType, strings.Join(missing, ", "))
+	}
+
+	req := &api.SdkCredentialCreateRequest{
+		Name:          params[credName],
+		EncryptionKey: params[api.OptCredEncrKey],
+	}
+	switch credType {
+	case "s3":
+		var disableSSL bool
+		if v := params[api.OptCredDisableSSL]; v != "" {
+			var err error
+			if disableSSL, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("Invalid %s %q: %v", api.OptCredDisableSSL, v, err)
+			}
+		}
+		req.CredentialType = &api.SdkCredentialCreateRequest_AwsCredential{
+			AwsCredential: &api.SdkAwsCredentialRequest{
+				AccessKey:  params[api.OptCredAccessKey],
+				SecretKey:  params[api.OptCredSecretKey],
+				Endpoint:   params[api.OptCredEndpoint],
+				Region:     params[api.OptCredRegion],
+				DisableSsl: disableSSL,
+			},
+		}
+	case "azure":
+		req.CredentialType = &api.SdkCredentialCreateRequest_AzureCredential{
+			AzureCredential: &api.SdkAzureCredentialRequest{
+				AccountName: params[api.OptCredAzureAccountName],
+				AccountKey:  params[api.OptCredAzureAccountKey],
+			},
+		}
+	case "google":
+		req.CredentialType = &api.SdkCredentialCreateRequest_GoogleCredential{
+			GoogleCredential: &api.SdkGoogleCredentialRequest{
+				ProjectId: params[api.OptCredGoogleProjectID],
+				JsonKey:   params[api.OptCredGoogleJsonKey],
+			},
+		}
+	}
+	return req, nil
+}
+
+// credParams converts the SDK credentials to the legacy parameters, with
+// the secrets redacted
+func credParams(cred *api.SdkCredentialInspectResponse) map[string]string {
+	params := map[string]string{
+		credName: cred.GetName(),
+	}
+	if aws := cred.GetAwsCredential(); aws != nil {
+		params[api.OptCredType] = "s3"
+		params[api.OptCredAccessKey] = aws.GetAccessKey()
+		params[api.OptCredEndpoint] = aws.GetEndpoint()
+		params[api.OptCredRegion] = aws.GetRegion()
+		params[api.OptCredDisableSSL] = strconv.FormatBool(aws.GetDisableSsl())
+	} else if azure := cred.GetAzureCredential(); azure != nil {
+		params[api.OptCredType] = "azure"
+		params[api.OptCredAzureAccountName] = azure.GetAccountName()
+	} else if google := cred.GetGoogleCredential(); google != nil {
+		params[api.OptCredType] = "google"
+		params[api.OptCredGoogleProjectID] = google.GetProjectId()
+	}
+	return redactCreds(params)
+}
+
 func (vd *volAPI) credsEnumerate(w http.ResponseWriter, r *http.Request) {
 	method := "credsEnumerate"
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getReadConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
+	credentials := api.NewOpenStorageCredentialsClient(conn)
 
-	creds, err := d.CredsEnumerate()
+	resp, err := credentials.Enumerate(ctx, &api.SdkCredentialEnumerateRequest{})
 	if err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
+
+	creds := make(map[string]interface{}, len(resp.GetCredentialIds()))
+	for _, id := range resp.GetCredentialIds() {
+		cred, err := credentials.Inspect(ctx, &api.SdkCredentialInspectRequest{
+			CredentialId: id,
+		})
+		if err != nil {
+			vd.sendStatusError(vd.name, method, w, err)
+			return
+		}
+		creds[id] = credParams(cred)
+	}
 	json.NewEncoder(w).Encode(creds)
 }
 
@@ -34,17 +178,29 @@ func (vd *volAPI) credsCreate(w http.ResponseWriter, r *http.Request) {
 		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	d, err := vd.getVolDriver(r)
+	vd.logRequest(method, input.InputParams[credName]).Infof("%v", redactCreds(input.InputParams))
+
+	req, err := sdkCredCreateRequest(input.InputParams)
 	if err != nil {
-		notFound(w, r)
+		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	response.UUID, err = d.CredsCreate(input.InputParams)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
+
+	resp, err := api.NewOpenStorageCredentialsClient(conn).Create(ctx, req)
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
+		return
+	}
+	response.UUID = resp.GetCredentialId()
 	json.NewEncoder(w).Encode(response)
 }
 
@@ -57,13 +213,20 @@ func (vd *volAPI) credsDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
-	if err = d.CredsDelete(uuid); err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusInternalServerError)
+
+	_, err = api.NewOpenStorageCredentialsClient(conn).Delete(ctx, &api.SdkCredentialDeleteRequest{
+		CredentialId: uuid,
+	})
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -77,14 +240,21 @@ func (vd *volAPI) credsValidate(w http.ResponseWriter, r *http.Request) {
 		vd.sendError(vd.name, method, w, "Could not parse form for uuid", http.StatusBadRequest)
 		return
 	}
-	d, err := vd.getVolDriver(r)
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 
-	if err := d.CredsValidate(uuid); err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusInternalServerError)
+	_, err = api.NewOpenStorageCredentialsClient(conn).Validate(ctx, &api.SdkCredentialValidateRequest{
+		CredentialId: uuid,
+	})
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+)
+
+func TestSdkCredCreateRequest(t *testing.T) {
+	s3 := map[string]string{
+		credName:             "backups",
+		api.OptCredType:      "s3",
+		api.OptCredAccessKey: "access",
+		api.OptCredSecretKey: "secret",
+		api.OptCredEndpoint:  "s3.example.com",
+		api.OptCredRegion:    "us-east-1",
+	}
+	with := func(params map[string]string, k, v string) map[string]string {
+		out := make(map[string]string, len(params)+1)
+		for pk, pv := range params {
+			out[pk] = pv
+		}
+		if len(v) == 0 {
+			delete(out, k)
+		} else {
+			out[k] = v
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		check  func(req *api.SdkCredentialCreateRequest) bool
+		err    string
+	}{
+		{
+			name:   "s3",
+			params: s3,
+			check: func(req *api.SdkCredentialCreateRequest) bool {
+				aws := req.GetAwsCredential()
+				return req.GetName() == "backups" && aws.GetAccessKey() == "access" &&
+					aws.GetSecretKey() == "secret" && aws.GetEndpoint() == "s3.example.com" &&
+					aws.GetRegion() == "us-east-1" && !aws.GetDisableSsl()
+			},
+		},
+		{
+			name:   "s3 without ssl",
+			params: with(s3, api.OptCredDisableSSL, "true"),
+			check: func(req *api.SdkCredentialCreateRequest) bool {
+				return req.GetAwsCredential().GetDisableSsl()
+			},
+		},
+		{
+			name:   "s3 with encryption key",
+			params: with(s3, api.OptCredEncrKey, "encryption"),
+			check: func(req *api.SdkCredentialCreateRequest) bool {
+				return req.GetEncryptionKey() == "encryption"
+			},
+		},
+		{
+			name:   "s3 invalid disable ssl",
+			params: with(s3, api.OptCredDisableSSL, "maybe"),
+			err:    "Invalid " + api.OptCredDisableSSL,
+		},
+		{
+			name:   "s3 missing fields",
+			params: with(with(s3, api.OptCredSecretKey, ""), api.OptCredRegion, ""),
+			err: "Missing required fields for s3 credentials: " +
+				api.OptCredSecretKey + ", " + api.OptCredRegion,
+		},
+		{
+			name:   "s3 missing name",
+			params: with(s3, credName, ""),
+			err:    "Missing required fields for s3 credentials: " + credName,
+		},
+		{
+			name: "azure",
+			params: map[string]string{
+				credName:                    "azure",
+				api.OptCredType:             "azure",
+				api.OptCredAzureAccountName: "account",
+				api.OptCredAzureAccountKey:  "key",
+			},
+			check: func(req *api.SdkCredentialCreateRequest) bool {
+				azure := req.GetAzureCredential()
+				return azure.GetAccountName() == "account" && azure.GetAccountKey() == "key"
+			},
+		},
+		{
+			name: "google",
+			params: map[string]string{
+				credName:                   "google",
+				api.OptCredType:            "google",
+				api.OptCredGoogleProjectID: "project",
+				api.OptCredGoogleJsonKey:   "{}",
+			},
+			check: func(req *api.SdkCredentialCreateRequest) bool {
+				google := req.GetGoogleCredential()
+				return google.GetProjectId() == "project" && google.GetJsonKey() == "{}"
+			},
+		},
+		{
+			name:   "google missing fields",
+			params: map[string]string{api.OptCredType: "google"},
+			err: "Missing required fields for google credentials: " + credName + ", " +
+				api.OptCredGoogleProjectID + ", " + api.OptCredGoogleJsonKey,
+		},
+		{
+			name:   "unknown type",
+			params: with(s3, api.OptCredType, "ftp"),
+			err:    "must be one of: azure, google, s3",
+		},
+		{
+			name:   "missing type",
+			params: with(s3, api.OptCredType, ""),
+			err:    "must be one of: azure, google, s3",
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := sdkCredCreateRequest(tt.params)
+		if len(tt.err) != 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, expected %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if !tt.check(req) {
+			t.Errorf("%s: unexpected request %v", tt.name, req)
+		}
+	}
+}
+
+func TestRedactCreds(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "secrets",
+			params: map[string]string{
+				credName:                   "backups",
+				api.OptCredAccessKey:       "access",
+				api.OptCredSecretKey:       "secret",
+				api.OptCredAzureAccountKey: "azure",
+				api.OptCredGoogleJsonKey:   "{}",
+				api.OptCredEncrKey:         "encryption",
+				api.OptCredRegion:          "us-east-1",
+			},
+			expected: map[string]string{
+				credName:                   "backups",
+				api.OptCredAccessKey:       redacted,
+				api.OptCredSecretKey:       redacted,
+				api.OptCredAzureAccountKey: redacted,
+				api.OptCredGoogleJsonKey:   redacted,
+				api.OptCredEncrKey:         redacted,
+				api.OptCredRegion:          "us-east-1",
+			},
+		},
+		{
+			name: "empty secrets",
+			params: map[string]string{
+				api.OptCredSecretKey: "",
+			},
+			expected: map[string]string{
+				api.OptCredSecretKey: "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if out := redactCreds(tt.params); !reflect.DeepEqual(out, tt.expected) {
+			t.Errorf("%s: redacted %v, expected %v", tt.name, out, tt.expected)
+		}
+	}
+
+	// The input is not modified
+	params := map[string]string{api.OptCredSecretKey: "secret"}
+	redactCreds(params)
+	if params[api.OptCredSecretKey] != "secret" {
+		t.Errorf("redactCreds modified its input")
+	}
+}
+
+func TestCredParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		cred     *api.SdkCredentialInspectResponse
+		expected map[string]string
+	}{
+		{
+			name: "s3",
+			cred: &api.SdkCredentialInspectResponse{
+				Name: "backups",
+				CredentialType: &api.SdkCredentialInspectResponse_AwsCredential{
+					AwsCredential: &api.SdkAwsCredentialResponse{
+						AccessKey:  "access",
+						Endpoint:   "s3.example.com",
+						Region:     "us-east-1",
+						DisableSsl: true,
+					},
+				},
+			},
+			expected: map[string]string{
+				credName:              "backups",
+				api.OptCredType:       "s3",
+				api.OptCredAccessKey:  redacted,
+				api.OptCredEndpoint:   "s3.example.com",
+				api.OptCredRegion:     "us-east-1",
+				api.OptCredDisableSSL: "true",
+			},
+		},
+		{
+			name: "azure",
+			cred: &api.SdkCredentialInspectResponse{
+				Name: "azure",
+				CredentialType: &api.SdkCredentialInspectResponse_AzureCredential{
+					AzureCredential: &api.SdkAzureCredentialResponse{
+						AccountName: "account",
+					},
+				},
+			},
+			expected: map[string]string{
+				credName:                    "azure",
+				api.OptCredType:             "azure",
+				api.OptCredAzureAccountName: "account",
+			},
+		},
+		{
+			name: "google",
+			cred: &api.SdkCredentialInspectResponse{
+				Name: "google",
+				CredentialType: &api.SdkCredentialInspectResponse_GoogleCredential{
+					GoogleCredential: &api.SdkGoogleCredentialResponse{
+						ProjectId: "project",
+					},
+				},
+			},
+			expected: map[string]string{
+				credName:                   "google",
+				api.OptCredType:            "google",
+				api.OptCredGoogleProjectID: "project",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if params := credParams(tt.cred); !reflect.DeepEqual(params, tt.expected) {
+			t.Errorf("%s: params %v, expected %v", tt.name, params, tt.expected)
+		}
+	}
+}
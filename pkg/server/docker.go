@@ -7,34 +7,115 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"context"
 
-	"github.com/libopenstorage/openstorage/config"
-	"github.com/libopenstorage/openstorage/pkg/grpcserver"
-	"github.com/libopenstorage/openstorage/pkg/options"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/docker/docker/pkg/mount"
 	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/api/errors"
 	"github.com/libopenstorage/openstorage/api/spec"
-	"github.com/libopenstorage/openstorage/pkg/util"
+	"github.com/libopenstorage/openstorage/config"
 	"github.com/libopenstorage/openstorage/volume"
-	"github.com/libopenstorage/openstorage/volume/drivers"
 )
 
 const (
 	// VolumeDriver is the string returned in the handshake protocol.
 	VolumeDriver = "VolumeDriver"
+
+	// ScopeGlobal reports volumes as visible from all the nodes in the cluster
+	ScopeGlobal = "global"
+	// ScopeLocal reports volumes as only visible from this node
+	ScopeLocal = "local"
+	// ScopeAuto determines the scope from the capabilities of the SDK server
+	ScopeAuto = "auto"
+
+	// DefaultCreateTimeout is the default timeout to create a volume
+	DefaultCreateTimeout = 90 * time.Second
+	// DefaultMountTimeout is the default timeout to mount or unmount a volume
+	DefaultMountTimeout = 90 * time.Second
+	// DefaultRemoveTimeout is the default timeout to remove a volume
+	DefaultRemoveTimeout = 60 * time.Second
+
+	// defaultTimeout is the timeout of the other operations
+	defaultTimeout = 30 * time.Second
 )
 
+// DriverOptions are the settings used to customize the volume plugin.
+type DriverOptions struct {
+	// Scope returned to Docker as part of VolumeDriver.Capabilities. It must
+	// be one of ScopeGlobal, ScopeLocal, or ScopeAuto.
+	Scope string
+	// StateDir is where the mount state is saved. If empty, the mount state
+	// is lost when the gateway restarts.
+	StateDir string
+	// Secrets resolves the secret keys of encrypted volumes. If nil, only
+	// the cluster default secret key can be used.
+	Secrets SecretProvider
+	// TokenFile contains the token used when a request does not provide one
+	TokenFile string
+	// DiscoverEndpoints adds the SDK endpoints of the other nodes in the
+	// cluster to the endpoints provided.
+	DiscoverEndpoints bool
+	// TLS configures TLS for the SDK endpoints. If nil, the connections to
+	// the SDK endpoints are not encrypted.
+	TLS *TLSOptions
+	// Timeouts bound the duration of the operations of the plugin
+	Timeouts Timeouts
+	// VolumeDefaults are the volume options used when creating a volume
+	// without them.
+	VolumeDefaults map[string]string
+	// Insecure allows sending tokens in plaintext to SDK endpoints which are
+	// not on this host when TLS is not configured. Otherwise such endpoints,
+	// including the discovered ones, are refused.
+	Insecure bool
+	// HostMounts is where the gateway sees the host directory in which the
+	// SDK server mounts the volumes, volume.MountBase. It is only set when the
+	// gateway runs in its own mount namespace, as a managed plugin does: the
+	// volumes are then bind mounted from there to the mountpoints returned
+	// to Docker.
+	HostMounts string
+}
+
+// Timeouts are the maximum durations of the operations of the plugin,
+// including all their calls to the SDK server. Zero values use the default
+// timeouts.
+type Timeouts struct {
+	// Create also applies to restoring a cloud backup
+	Create time.Duration
+	// Mount also applies to unmount and to waiting for a restore
+	Mount  time.Duration
+	Remove time.Duration
+}
+
 // Implementation of the Docker volumes plugin specification.
 type driver struct {
 	restBase
 	spec.SpecHandler
 
 	sdkUds string
-	conn   *grpc.ClientConn
+	sdk    *endpointPool
+	mounts *mountStore
+
+	// The options and the token file can be changed while running
+	optsLock sync.RWMutex
+	opts     DriverOptions
+	token    *tokenFile
+
+	scopeLock sync.Mutex
+	scope     string
+
+	// node is the node of the local SDK endpoint, see localNode
+	nodeLock sync.Mutex
+	node     *api.StorageNode
 }
 
 type handshakeResp struct {
@@ -73,13 +154,94 @@ type capabilitiesResponse struct {
 	Capabilities capabilities
 }
 
-func newVolumePlugin(name, sdkUds string) restServer {
+func newVolumePlugin(name, sdkUds string, opts *DriverOptions) (*driver, error) {
 	d := &driver{
 		restBase:    restBase{name: name, version: "0.3"},
 		SpecHandler: spec.NewSpecHandler(),
 		sdkUds:      sdkUds,
 	}
-	return d
+	if opts == nil {
+		opts = &DriverOptions{}
+	}
+	if err := d.setOptions(opts); err != nil {
+		return nil, err
+	}
+	d.opts.StateDir = opts.StateDir
+	d.opts.DiscoverEndpoints = opts.DiscoverEndpoints
+	d.opts.TLS = opts.TLS
+	d.opts.HostMounts = opts.HostMounts
+	d.opts.Insecure = opts.Insecure
+
+	var creds credentials.TransportCredentials
+	if d.opts.TLS.Enabled() {
+		tlsCreds, err := newTLSCredentials(d.opts.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup TLS: %v", err)
+		}
+		creds = tlsCreds
+	}
+
+	var err error
+	if d.sdk, err = newEndpointPool(sdkUds, creds, d.opts.Insecure); err != nil {
+		return nil, err
+	}
+
+	if d.mounts, err = newMountStore(d.opts.StateDir); err != nil {
+		return nil, fmt.Errorf("Failed to load mount state from %s: %v",
+			d.opts.StateDir, err)
+	}
+	go d.reconcileMounts()
+
+	if d.opts.DiscoverEndpoints {
+		go d.discoverEndpoints()
+	}
+
+	return d, nil
+}
+
+// options returns the current options of the plugin
+func (d *driver) options() DriverOptions {
+	d.optsLock.RLock()
+	defer d.optsLock.RUnlock()
+	return d.opts
+}
+
+// setOptions applies the options which can be changed while the plugin is
+// running: the scope, the default token file, the secret provider, the
+// timeouts and the volume defaults.
+func (d *driver) setOptions(opts *DriverOptions) error {
+	scope := opts.Scope
+	switch scope {
+	case "":
+		scope = ScopeAuto
+	case ScopeGlobal, ScopeLocal, ScopeAuto:
+	default:
+		return fmt.Errorf("Invalid scope %s. Must be one of %s, %s, or %s",
+			scope, ScopeGlobal, ScopeLocal, ScopeAuto)
+	}
+
+	d.optsLock.Lock()
+	defer d.optsLock.Unlock()
+
+	if scope != d.opts.Scope {
+		d.scopeLock.Lock()
+		d.scope = ""
+		d.scopeLock.Unlock()
+	}
+	d.opts.Scope = scope
+
+	if opts.TokenFile != d.opts.TokenFile || d.token == nil {
+		d.token = nil
+		if len(opts.TokenFile) != 0 {
+			d.token = newTokenFile(opts.TokenFile)
+		}
+	}
+	d.opts.TokenFile = opts.TokenFile
+
+	d.opts.Secrets = opts.Secrets
+	d.opts.Timeouts = opts.Timeouts
+	d.opts.VolumeDefaults = opts.VolumeDefaults
+	return nil
 }
 
 func volDriverPath(method string) string {
@@ -87,7 +249,16 @@ func volDriverPath(method string) string {
 }
 
 func (d *driver) volNotFound(request string, id string, e error, w http.ResponseWriter) error {
-	err := fmt.Errorf("Failed to locate volume: " + e.Error())
+	if err := accessError(id, e); err != e {
+		d.logRequest(request, id).Warnln(http.StatusForbidden, " ", err.Error())
+		return err
+	}
+	// The volume may exist, the request can be retried
+	if c := status.Code(e); c == codes.DeadlineExceeded || c == codes.Unavailable {
+		d.logRequest(request, id).Warnln(httpStatus(e), " ", e.Error())
+		return e
+	}
+	err := fmt.Errorf("Failed to locate volume: %v", translateError(e))
 	if e == volume.ErrDriverInitializing {
 		d.logRequest(request, id).Warnln(http.StatusInternalServerError, " ", err.Error())
 	} else {
@@ -106,12 +277,12 @@ func (d *driver) Routes() []*Route {
 	return []*Route{
 		{verb: "POST", path: volDriverPath("Create"), fn: d.create},
 		{verb: "POST", path: volDriverPath("Remove"), fn: d.remove},
-		//{verb: "POST", path: volDriverPath("Mount"), fn: d.mount},
-		//{verb: "POST", path: volDriverPath("Path"), fn: d.path},
-		//{verb: "POST", path: volDriverPath("List"), fn: d.list},
-		//{verb: "POST", path: volDriverPath("Get"), fn: d.get},
-		//{verb: "POST", path: volDriverPath("Unmount"), fn: d.unmount},
-		//{verb: "POST", path: volDriverPath("Capabilities"), fn: d.capabilities},
+		{verb: "POST", path: volDriverPath("Mount"), fn: d.mount},
+		{verb: "POST", path: volDriverPath("Path"), fn: d.path},
+		{verb: "POST", path: volDriverPath("List"), fn: d.list},
+		{verb: "POST", path: volDriverPath("Get"), fn: d.get},
+		{verb: "POST", path: volDriverPath("Unmount"), fn: d.unmount},
+		{verb: "POST", path: volDriverPath("Capabilities"), fn: d.capabilities},
 		{verb: "POST", path: "/Plugin.Activate", fn: d.handshake},
 		//{verb: "GET", path: "/status", fn: d.status},
 	}
@@ -122,19 +293,215 @@ func (d *driver) emptyResponse(w http.ResponseWriter) {
 }
 
 func (d *driver) errorResponse(method string, w http.ResponseWriter, err error) {
+	if s, ok := status.FromError(err); ok && s.Code() == codes.DeadlineExceeded {
+		err = fmt.Errorf("%s timed out after %v waiting for the SDK server: %s",
+			method, d.timeout(method), s.Message())
+	}
 	if err == volume.ErrDriverInitializing {
 		d.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
 	} else {
-		json.NewEncoder(w).Encode(&volumeResponse{Err: err.Error()})
+		json.NewEncoder(w).Encode(&volumeResponse{Err: translateError(err).Error()})
+	}
+}
+
+// isNotFound returns true if the SDK returned a NotFound status.
+func isNotFound(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.NotFound
+}
+
+// isAccessDenied returns true if the SDK refused the token of the request
+func isAccessDenied(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && (s.Code() == codes.PermissionDenied || s.Code() == codes.Unauthenticated)
+}
+
+// isAlreadyExists returns true if the SDK returned an AlreadyExists status.
+func isAlreadyExists(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.AlreadyExists
+}
+
+// inspectVolume returns the volume with the given name or id using the SDK.
+// The SDK may match the name as a pattern, so only volumes whose name is an
+// exact match are considered.
+func (d *driver) inspectVolume(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	name string,
+) (*api.Volume, error) {
+	resp, err := volumes.EnumerateWithFilters(ctx, &api.SdkVolumeEnumerateWithFiltersRequest{
+		Locator: &api.VolumeLocator{
+			Name: name,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*api.Volume, 0, 1)
+	for _, id := range resp.GetVolumeIds() {
+		inspect, err := volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+			VolumeId: id,
+		})
+		if err != nil {
+			// The volume may have been deleted since the enumerate
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if inspect.GetVolume().GetLocator().GetName() == name {
+			matches = append(matches, inspect.GetVolume())
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		// Docker may also hand us a volume id instead of a name
+		inspect, err := volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+			VolumeId: name,
+		})
+		if err != nil {
+			// The SDK denies access to the volumes of other users
+			if !isNotFound(err) {
+				return nil, err
+			}
+			return nil, &errors.ErrNotFound{
+				Type: "Volume",
+				ID:   name,
+			}
+		}
+		return inspect.GetVolume(), nil
+	case 1:
+		return matches[0], nil
+	default:
+		ids := make([]string, len(matches))
+		for i, v := range matches {
+			ids[i] = v.GetId()
+		}
+		return nil, fmt.Errorf("Volume name %s is ambiguous, it matches volumes %s",
+			name, strings.Join(ids, ", "))
 	}
 }
 
-func (d *driver) volFromName(name string) (*api.Volume, error) {
-	v, err := volumedrivers.Get(d.name)
+// localNode returns the node of the local SDK endpoint. It is only looked up
+// once, since the local endpoint always serves the same node.
+func (d *driver) localNode(ctx context.Context) (*api.StorageNode, error) {
+	d.nodeLock.Lock()
+	defer d.nodeLock.Unlock()
+
+	if d.node != nil {
+		return d.node, nil
+	}
+	conn, err := d.getLocalConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := api.NewOpenStorageNodeClient(conn).InspectCurrent(ctx,
+		&api.SdkNodeInspectCurrentRequest{})
+	if err != nil {
+		return nil, err
+	}
+	d.node = resp.GetNode()
+	return d.node, nil
+}
+
+// isAttachedOn returns true if attachedOn, the node id or address reported
+// by the SDK server for an attached volume, is the node.
+func isAttachedOn(node *api.StorageNode, attachedOn string) bool {
+	return len(attachedOn) != 0 &&
+		(attachedOn == node.GetId() || attachedOn == node.GetMgmtIp() ||
+			attachedOn == node.GetDataIp())
+}
+
+// volumeMountpoint returns where the volume is mounted on this node or an
+// empty string if it is not mounted here.
+func (d *driver) volumeMountpoint(ctx context.Context, vol *api.Volume) string {
+	// Volumes mounted for Docker, including scaled volumes which are
+	// mounted through one of their siblings
+	if mountpoint := d.mounts.mountpoint(vol.GetId()); len(mountpoint) != 0 {
+		return mountpoint
+	}
+	if vol.GetSpec().GetScale() > 1 || len(vol.GetAttachPath()) == 0 {
+		return ""
+	}
+
+	// Otherwise the attach path is only valid on the node it is attached to
+	node, err := d.localNode(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot locate volume driver for %s: %s", d.name, err.Error())
+		d.logRequest("mountpoint", vol.GetLocator().GetName()).Warnf(
+			"Cannot find the local node: %v", err)
+		return ""
 	}
-	return util.VolumeFromName(v, name)
+	if !isAttachedOn(node, vol.GetAttachedOn()) {
+		return ""
+	}
+	return path.Join(vol.GetAttachPath()[0], config.DataDir)
+}
+
+// timeout returns the timeout of the operation, or its default timeout if
+// it is not set
+func (d *driver) timeout(method string) time.Duration {
+	timeout, fallback := time.Duration(0), defaultTimeout
+	timeouts := d.options().Timeouts
+	switch method {
+	case "create":
+		timeout, fallback = timeouts.Create, DefaultCreateTimeout
+	case "mount", "unmount":
+		timeout, fallback = timeouts.Mount, DefaultMountTimeout
+	case "remove":
+		timeout, fallback = timeouts.Remove, DefaultRemoveTimeout
+	}
+	if timeout <= 0 {
+		return fallback
+	}
+	return timeout
+}
+
+// requestContext returns the context used for the SDK calls of a request. It
+// is canceled when Docker cancels the request or when the timeout of the
+// operation expires.
+func (d *driver) requestContext(
+	r *http.Request,
+	method, name string,
+	opts map[string]string,
+) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(r.Context(), d.timeout(method))
+	return d.tokenContext(ctx, name, opts), cancel
+}
+
+// tokenContext returns a context with the authorization token found either
+// in the volume name, in the volume options, or in the default token file.
+func (d *driver) tokenContext(
+	ctx context.Context,
+	name string,
+	opts map[string]string,
+) context.Context {
+	token, tokenInName := d.GetTokenFromString(name)
+	if !tokenInName {
+		token = opts[api.Token]
+	}
+	if len(token) == 0 {
+		d.optsLock.RLock()
+		defaultToken := d.token
+		d.optsLock.RUnlock()
+		if defaultToken != nil {
+			token = defaultToken.get()
+		}
+	}
+	if len(token) == 0 {
+		return ctx
+	}
+	return withToken(ctx, token)
+}
+
+// withToken returns a context sending the token to the SDK server
+func withToken(ctx context.Context, token string) context.Context {
+	md := metadata.New(map[string]string{
+		"authorization": "bearer " + token,
+	})
+	return metadata.NewOutgoingContext(ctx, md)
 }
 
 func (d *driver) decode(method string, w http.ResponseWriter, r *http.Request) (*volumeRequest, error) {
@@ -179,32 +546,50 @@ func (d *driver) mountpath(name string) string {
 	return path.Join(volume.MountBase, name)
 }
 
-func (d *driver) getConn() (*grpc.ClientConn, error) {
+// getConn returns a connection to an available SDK endpoint
+func (d *driver) getConn(ctx context.Context) (*grpc.ClientConn, error) {
+	return d.sdk.Failover(ctx)
+}
 
-	if d.conn == nil {
-		var err error
-		d.conn, err = grpcserver.Connect(
-			d.sdkUds,
-			[]grpc.DialOption{grpc.WithInsecure()})
-		if err != nil {
-			return nil, fmt.Errorf("Failed to connect to gRPC handler: %v", err)
-		}
-	}
-	return d.conn, nil
+// getLocalConn returns a connection to the SDK endpoint of the local node,
+// which must be used to attach, mount, unmount and detach volumes.
+func (d *driver) getLocalConn(ctx context.Context) (*grpc.ClientConn, error) {
+	return d.sdk.Local(ctx)
+}
+
+// getReadConn returns a connection for read-only calls, which are spread
+// across the SDK endpoints.
+func (d *driver) getReadConn(ctx context.Context) (*grpc.ClientConn, error) {
+	return d.sdk.Spread(ctx)
 }
 
 func (d *driver) create(w http.ResponseWriter, r *http.Request) {
 	method := "create"
-	fmt.Printf("start %v", *r)
 	request, err := d.decode(method, w, r)
 	if err != nil {
 		return
 	}
-	fmt.Println("Create request received:", request)
 
+	// Only the options given by the user are compared with an existing volume
 	specParsed, spec, locator, source, name := d.SpecFromString(request.Name)
+	keys := requestedKeys(specParsed, request.Name, request.Opts)
+
+	// The volume defaults apply to the options which are not provided
+	for k, v := range d.options().VolumeDefaults {
+		if request.Opts == nil {
+			request.Opts = make(map[string]string)
+		}
+		if _, ok := request.Opts[k]; !ok {
+			request.Opts[k] = v
+		}
+	}
+
 	d.logRequest(method, name).Infoln("")
 
+	if err := rejectPassphrase(request.Name, request.Opts); err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
 	if !specParsed {
 		spec, locator, source, err = d.SpecFromOpts(request.Opts)
 		if err != nil {
@@ -213,26 +598,96 @@ func (d *driver) create(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get the token and place it in the context
-	token, tokenInName := d.GetTokenFromString(request.Name)
-	if !tokenInName {
-		token = request.Opts[api.Token]
+	// The spec handler parses the secret key as the passphrase. Only the
+	// reference to the secret is saved with the volume, the secret itself is
+	// resolved when the volume is attached.
+	secretKey := spec.Passphrase
+	spec.Passphrase = ""
+	if len(secretKey) != 0 {
+		if locator.VolumeLabels == nil {
+			locator.VolumeLabels = make(map[string]string)
+		}
+		locator.VolumeLabels[SecretKeyOpt] = secretKey
+		spec.Encrypted = true
 	}
-	md := metadata.New(map[string]string{
-		"authorization": "bearer " + token,
-	})
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
+
+	// Get the token and place it in the context
+	ctx, cancel := d.requestContext(r, method, request.Name, request.Opts)
+	defer cancel()
 
 	// get grpc connection
-	conn, err := d.getConn()
+	conn, err := d.getConn(ctx)
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
+
+	// Options to create the volume from a snapshot or backup are not
+	// labels of the volume.
+	fromSnapshot := request.Opts[FromSnapshotOpt]
+	fromBackup := request.Opts[FromBackupOpt]
+	credID := request.Opts[CredOpt]
+	for _, k := range []string{FromSnapshotOpt, FromBackupOpt, CredOpt} {
+		delete(locator.VolumeLabels, k)
+	}
+	if len(fromSnapshot) != 0 && len(fromBackup) != 0 {
+		d.errorResponse(method, w, fmt.Errorf(
+			"Only one of %s or %s can be provided", FromSnapshotOpt, FromBackupOpt))
+		return
+	}
+	if len(fromSnapshot) != 0 || len(fromBackup) != 0 {
+		if err := checkSourceOpts(keys); err != nil {
+			d.errorResponse(method, w, err)
+			return
+		}
+	}
+
+	// Ownership options are not labels of the volume either
+	ownership, err := ownershipFromOpts(request.Opts)
 	if err != nil {
 		d.errorResponse(method, w, err)
 		return
 	}
+	for _, k := range []string{OwnerGroupsOpt, CollaboratorsOpt, PublicOpt} {
+		delete(locator.VolumeLabels, k)
+	}
+	spec.Ownership = ownership
 
 	spec.VolumeLabels = locator.VolumeLabels
 	volumes := api.NewOpenStorageVolumeClient(conn)
-	if source != nil && len(source.Parent) != 0 {
+
+	if len(fromSnapshot) != 0 {
+		snapID, err := d.snapshotID(ctx, volumes, fromSnapshot)
+		if err != nil {
+			d.errorResponse(method, w, err)
+			return
+		}
+		source = &api.Source{Parent: snapID}
+	}
+
+	// Docker calls Create again for volumes which already exist. A volume
+	// restored from a backup is only reported once the restore completes.
+	existing, err := d.existingVolume(ctx, volumes, name, keys, spec, source)
+	if err == nil && existing != nil && len(fromBackup) != 0 {
+		err = d.waitRestore(ctx, conn, method, name, existing.GetId())
+	}
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	} else if existing != nil {
+		d.logRequest(method, name).Infof("volume already exists")
+		d.emptyResponse(w)
+		return
+	}
+
+	if len(fromBackup) != 0 {
+		// restore, waiting for it to complete within the create timeout
+		var id string
+		id, err = d.restoreBackup(ctx, conn, name, fromBackup, credID)
+		if err == nil {
+			err = d.waitRestore(ctx, conn, method, name, id)
+		}
+	} else if source != nil && len(source.Parent) != 0 {
 		// clone
 		_, err = volumes.Clone(ctx, &api.SdkVolumeCloneRequest{
 			Name:     name,
@@ -240,16 +695,21 @@ func (d *driver) create(w http.ResponseWriter, r *http.Request) {
 		})
 	} else {
 		// create
-		resp, err := volumes.Create(ctx, &api.SdkVolumeCreateRequest{
+		var resp *api.SdkVolumeCreateResponse
+		resp, err = volumes.Create(ctx, &api.SdkVolumeCreateRequest{
 			Name: name,
 			Spec: spec,
 		})
-		if err != nil {
-			d.errorResponse(method, w, err)
-			return
+		if err == nil {
+			d.logRequest(method, name).Infof("created volume %s", resp.GetVolumeId())
+		}
+	}
+	if isAlreadyExists(err) {
+		// Another request created the volume after we checked
+		existing, err = d.existingVolume(ctx, volumes, name, keys, spec, source)
+		if err == nil && existing != nil && len(fromBackup) != 0 {
+			err = d.waitRestore(ctx, conn, method, name, existing.GetId())
 		}
-
-		fmt.Println(resp.GetVolumeId())
 	}
 	if err != nil {
 		d.errorResponse(method, w, err)
@@ -265,11 +725,9 @@ func (d *driver) remove(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-	fmt.Println("Delete request received", request)
 
-	specParsed, _, locator, _, name := d.SpecFromString(request.Name)
+	specParsed, _, _, _, name := d.SpecFromString(request.Name)
 	d.logRequest(method, name).Infoln("")
-	fmt.Println("spec parsed:", "name", name, "locator", locator)
 
 	if !specParsed {
 		_, _, _, err = d.SpecFromOpts(request.Opts)
@@ -280,87 +738,137 @@ func (d *driver) remove(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the token and place it in the context
-	token, tokenInName := d.GetTokenFromString(request.Name)
-	if !tokenInName {
-		token = request.Opts[api.Token]
-	}
-	md := metadata.New(map[string]string{
-		"authorization": "bearer " + token,
-	})
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	ctx, cancel := d.requestContext(r, method, request.Name, request.Opts)
+	defer cancel()
 
 	// get grpc connection
-	conn, err := d.getConn()
+	conn, err := d.getConn(ctx)
 	if err != nil {
 		d.errorResponse(method, w, err)
 		return
 	}
 	volumes := api.NewOpenStorageVolumeClient(conn)
 
-	// get id to deletes
-	fmt.Println("***NAME", name)
-	fmt.Println("***locator", locator)
-	resp, err := volumes.EnumerateWithFilters(ctx, &api.SdkVolumeEnumerateWithFiltersRequest{
-		//Locator: locator,
-		Locator: &api.VolumeLocator{
-			Name: name,
-		},
-	})
-	if err != nil {
-		d.errorResponse(method, w, err)
+	if err := d.removeVolume(ctx, volumes, name); err != nil {
+		d.errorResponse(method, w, accessError(name, err))
 		return
 	}
-	fmt.Println("resp", resp)
-	fmt.Println("resp", resp.VolumeIds)
 
-	// delete volume
-	// TODO handle vol ids empty or >1
+	json.NewEncoder(w).Encode(&volumeResponse{})
+}
+
+// removeVolume deletes the volume with the given name or id. Docker retries
+// removes, so a volume which is already gone is not an error.
+func (d *driver) removeVolume(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	name string,
+) error {
+	method := "remove"
+
+	vol, err := d.inspectVolume(ctx, volumes, name)
+	if err != nil {
+		if _, ok := err.(*errors.ErrNotFound); ok {
+			d.logRequest(method, name).Infof("volume already deleted")
+			return nil
+		}
+		return err
+	}
+
 	_, err = volumes.Delete(ctx, &api.SdkVolumeDeleteRequest{
-		VolumeId: resp.VolumeIds[0],
+		VolumeId: vol.GetId(),
 	})
 	if err != nil {
-		d.errorResponse(method, w, err)
-		return
+		if !isNotFound(err) {
+			return err
+		}
+		d.logRequest(method, name).Infof("volume %s already deleted", vol.GetId())
 	}
+	return nil
+}
 
-	json.NewEncoder(w).Encode(&volumeResponse{})
+// enumerateVolumes returns the volumes matching the locator
+func (d *driver) enumerateVolumes(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	locator *api.VolumeLocator,
+) ([]*api.Volume, error) {
+	resp, err := volumes.EnumerateWithFilters(ctx, &api.SdkVolumeEnumerateWithFiltersRequest{
+		Locator: locator,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vols := make([]*api.Volume, 0, len(resp.GetVolumeIds()))
+	for _, id := range resp.GetVolumeIds() {
+		inspect, err := volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+			VolumeId: id,
+		})
+		if err != nil {
+			// The volume may have been deleted since the enumerate
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		vols = append(vols, inspect.GetVolume())
+	}
+	return vols, nil
+}
+
+// createVolume creates a volume and returns it
+func (d *driver) createVolume(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	name string,
+	spec *api.VolumeSpec,
+) (*api.Volume, error) {
+	resp, err := volumes.Create(ctx, &api.SdkVolumeCreateRequest{
+		Name: name,
+		Spec: spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	inspect, err := volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+		VolumeId: resp.GetVolumeId(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inspect.GetVolume(), nil
 }
 
 func (d *driver) scaleUp(
+	ctx context.Context,
 	method string,
-	vd volume.VolumeDriver,
+	volumes api.OpenStorageVolumeClient,
+	mountAttach api.OpenStorageMountAttachClient,
 	inVol *api.Volume,
 	allVols []*api.Volume,
-	attachOptions map[string]string,
+	attachOptions *api.SdkVolumeAttachOptions,
 ) (
 	outVol *api.Volume,
 	err error,
 ) {
 	// Create new volume if existing volumes are not available.
-	spec := inVol.Spec.Copy()
+	spec := inVol.GetSpec().Copy()
 	spec.Scale = 1
 	spec.ReplicaSet = nil
 	volCount := len(allVols)
-	for i := len(allVols); volCount < int(inVol.Spec.Scale); i++ {
-		name := fmt.Sprintf("%s_%03d", inVol.Locator.Name, i)
-		id := ""
-		if id, err = vd.Create(
-			&api.VolumeLocator{Name: name},
-			nil,
-			spec,
-		); err != nil {
+	for i := len(allVols); volCount < int(inVol.GetSpec().GetScale()); i++ {
+		name := fmt.Sprintf("%s_%03d", inVol.GetLocator().GetName(), i)
+		if outVol, err = d.createVolume(ctx, volumes, name, spec); err != nil {
 			// It is possible to get an error on a name conflict
 			// either due to concurrent creates or holes punched in
 			// from previous deletes.
-			if err == volume.ErrExist {
+			if isAlreadyExists(err) {
 				continue
 			}
 			return nil, err
 		}
-		if outVol, err = d.volFromName(id); err != nil {
-			return nil, err
-		}
-		if _, err = vd.Attach(outVol.Id, attachOptions); err == nil {
+		if _, err = d.attachVol(ctx, method, mountAttach, outVol, attachOptions); err == nil {
 			return outVol, nil
 		}
 		// If we fail to attach the volume, continue to look for a
@@ -371,192 +879,237 @@ func (d *driver) scaleUp(
 }
 
 func (d *driver) attachScale(
+	ctx context.Context,
 	method string,
-	vd volume.VolumeDriver,
+	volumes api.OpenStorageVolumeClient,
+	mountAttach api.OpenStorageMountAttachClient,
 	inVol *api.Volume,
-	attachOptions map[string]string,
+	attachOptions *api.SdkVolumeAttachOptions,
 ) (
 	*api.Volume,
 	error,
 ) {
 	// Find a volume that has data local to this node.
-	vols, err := vd.Enumerate(
-		&api.VolumeLocator{
-			Name: fmt.Sprintf("%s.*", inVol.Locator.Name),
-			VolumeLabels: map[string]string{
-				volume.LocationConstraint: volume.LocalNode,
-			},
+	vols, err := d.enumerateVolumes(ctx, volumes, &api.VolumeLocator{
+		Name: fmt.Sprintf("%s.*", inVol.GetLocator().GetName()),
+		VolumeLabels: map[string]string{
+			volume.LocationConstraint: volume.LocalNode,
 		},
-		nil,
-	)
+	})
 	// Try to attach local volumes.
 	if err == nil {
 		for _, vol := range vols {
-			if v, err := d.attachVol(method, vd, vol, attachOptions); err == nil {
+			if v, err := d.attachVol(ctx, method, mountAttach, vol, attachOptions); err == nil {
 				return v, nil
 			}
 		}
 	}
 	// Create a new local volume if we fail to attach existing local volume
 	// or if none exist.
-	allVols, err := vd.Enumerate(
-		&api.VolumeLocator{
-			Name: fmt.Sprintf("%s.*", inVol.Locator.Name),
-		},
-		nil,
-	)
+	allVols, err := d.enumerateVolumes(ctx, volumes, &api.VolumeLocator{
+		Name: fmt.Sprintf("%s.*", inVol.GetLocator().GetName()),
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// Try to attach existing volumes.
 	for _, outVol := range allVols {
-		if _, err = vd.Attach(outVol.Id, attachOptions); err == nil {
+		if _, err = d.attachVol(ctx, method, mountAttach, outVol, attachOptions); err == nil {
 			return outVol, nil
 		}
 	}
 
-	if len(allVols) < int(inVol.Spec.Scale) {
-		name := fmt.Sprintf("%s_%03d", inVol.Locator.Name, len(allVols))
-		spec := inVol.Spec.Copy()
+	if len(allVols) < int(inVol.GetSpec().GetScale()) {
+		name := fmt.Sprintf("%s_%03d", inVol.GetLocator().GetName(), len(allVols))
+		spec := inVol.GetSpec().Copy()
 		spec.ReplicaSet = &api.ReplicaSet{Nodes: []string{volume.LocalNode}}
 		spec.Scale = 1
-		id, err := vd.Create(&api.VolumeLocator{Name: name}, nil, spec)
+		outVol, err := d.createVolume(ctx, volumes, name, spec)
 		if err != nil {
-			return d.scaleUp(method, vd, inVol, allVols, attachOptions)
-		}
-		outVol, err := d.volFromName(id)
-		if err != nil {
-			return nil, err
+			return d.scaleUp(ctx, method, volumes, mountAttach, inVol, allVols, attachOptions)
 		}
-		if _, err = vd.Attach(outVol.Id, attachOptions); err == nil {
+		if _, err = d.attachVol(ctx, method, mountAttach, outVol, attachOptions); err == nil {
 			return outVol, nil
 		}
 		// We failed to attach, scaleUp.
 		allVols = append(allVols, outVol)
 	}
-	return d.scaleUp(method, vd, inVol, allVols, attachOptions)
+	return d.scaleUp(ctx, method, volumes, mountAttach, inVol, allVols, attachOptions)
 }
 
 func (d *driver) attachVol(
+	ctx context.Context,
 	method string,
-	vd volume.VolumeDriver,
+	mountAttach api.OpenStorageMountAttachClient,
 	vol *api.Volume,
-	attachOptions map[string]string,
+	attachOptions *api.SdkVolumeAttachOptions,
 ) (
 	outVolume *api.Volume,
 	err error,
 ) {
-	attachPath, err := vd.Attach(vol.Id, attachOptions)
-
-	switch err {
-	case nil:
-		d.logRequest(method, vol.Locator.Name).Debugf(
-			"response %v", attachPath)
-		return vol, nil
-	case volume.ErrVolAttachedOnRemoteNode:
-		d.logRequest(method, vol.Locator.Name).Infof(
-			"Mount volume attached on remote node.")
-		return vol, err
-	default:
-		d.logRequest(method, vol.Locator.Name).Warnf(
+	// The SDK server will decide if the volume needs an attach
+	// depending on the driver.
+	resp, err := mountAttach.Attach(ctx, &api.SdkVolumeAttachRequest{
+		VolumeId: vol.GetId(),
+		Options:  attachOptions,
+	})
+	if err != nil {
+		d.logRequest(method, vol.GetLocator().GetName()).Warnf(
 			"Cannot attach volume: %v", err.Error())
 		return vol, err
 	}
+	d.logRequest(method, vol.GetLocator().GetName()).Debugf(
+		"response %v", resp.GetDevicePath())
+	return vol, nil
 }
 
-func (d *driver) attachOptionsFromSpec(
+// attachOptions returns the options needed to attach the volume. The secret
+// of an encrypted volume is resolved from the secret key in the volume name,
+// which the spec handler parses as the passphrase, or from the secret key
+// saved with the volume. If neither is available, the SDK server uses the
+// cluster default secret key.
+func (d *driver) attachOptions(
 	spec *api.VolumeSpec,
-) map[string]string {
-	if spec.Passphrase != "" {
-		opts := make(map[string]string)
-		opts[options.OptionsSecret] = spec.Passphrase
-		return opts
+	vol *api.Volume,
+) (*api.SdkVolumeAttachOptions, error) {
+	secretKey := volumeSecretKey(vol)
+	if len(spec.Passphrase) != 0 {
+		secretKey = spec.Passphrase
 	}
-	return nil
+	if len(secretKey) == 0 {
+		return nil, nil
+	}
+	secrets := d.options().Secrets
+	if secrets == nil {
+		return nil, fmt.Errorf("Volume %s references secret key %s but no secret provider is configured",
+			vol.GetLocator().GetName(), secretKey)
+	}
+	secret, err := secrets.GetSecret(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return &api.SdkVolumeAttachOptions{
+		SecretName: secret,
+	}, nil
 }
 
 func (d *driver) mount(w http.ResponseWriter, r *http.Request) {
 	var response volumePathResponse
 	method := "mount"
 
-	v, err := volumedrivers.Get(d.name)
+	request, err := d.decodeMount(method, w, r)
 	if err != nil {
-		d.logRequest(method, "").Warnf("Cannot locate volume driver")
-		d.errorResponse(method, w, err)
 		return
 	}
+	_, spec, _, _, name := d.SpecFromString(request.Name)
+	ctx, cancel := d.requestContext(r, method, request.Name, nil)
+	defer cancel()
 
-	request, err := d.decodeMount(method, w, r)
+	conn, err := d.getLocalConn(ctx)
 	if err != nil {
 		d.errorResponse(method, w, err)
 		return
 	}
-	_, spec, _, _, name := d.SpecFromString(request.Name)
-	attachOptions := d.attachOptionsFromSpec(spec)
-	vol, err := d.volFromName(name)
+	volumes := api.NewOpenStorageVolumeClient(conn)
+	mountAttach := api.NewOpenStorageMountAttachClient(conn)
+
+	vol, err := d.inspectVolume(ctx, volumes, name)
 	if err != nil {
-		d.sendError(method, "", w, err.Error(), http.StatusBadRequest)
+		e := d.volNotFound(method, name, err, w)
+		d.errorResponse(method, w, e)
 		return
 	}
 
-	// If a scaled volume is already mounted, check if it can be unmounted and
-	// detached. If not return an error.
-	mountpoint := d.mountpath(name)
-	if vol.Spec.Scale > 1 {
-		id := v.MountedAt(mountpoint)
-		if len(id) != 0 {
-			err = v.Unmount(id, mountpoint, nil)
-			if err != nil {
-				d.logRequest(method, "").Warnf("Error unmounting scaled volume: %v", err)
-				err = fmt.Errorf("Cannot remount scaled volume(%v)."+
-					" Volume %v is mounted at %v", name, id, mountpoint)
-				d.errorResponse(method, w, err)
-				return
-			}
-
-			if v.Type() == api.DriverType_DRIVER_TYPE_BLOCK {
-				err = v.Detach(id, nil)
-				if err != nil {
-					d.logRequest(method, "").Warnf("Error detaching scaled volume: %v", err)
-					mountErr := v.Mount(id, mountpoint, nil)
-					if mountErr != nil {
-						d.logRequest(method, "").Warnf("Error remounting scaled volume: %v", mountErr.Error())
-					}
-					err = fmt.Errorf("Cannot remount scaled volume(%v)."+
-						" Volume %v is mounted at %v", name, id, mountpoint)
-					d.logRequest(method, "").Warnf(err.Error())
-					d.errorResponse(method, w, err)
-					return
-				}
-			}
+	unlock, err := d.mounts.lockVolume(ctx, vol.GetId())
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
+	defer unlock()
+
+	// The volume is only attached and mounted for the first container. The
+	// references may be stale if the gateway restarted and the mount state
+	// is not reconciled yet, so the mount itself is checked too.
+	response.Mountpoint = d.mountpath(name)
+	if refs := d.mounts.count(vol.GetId()); refs != 0 {
+		mountpoint := d.mounts.mountpoint(vol.GetId())
+		if mounted, err := mount.Mounted(mountpoint); err != nil || !mounted {
+			d.logRequest(method, request.Name).Warnf(
+				"Volume has %d references but is not mounted on %s, mounting it again",
+				refs, mountpoint)
+			d.mounts.clear(vol.GetId())
 		}
 	}
+	if d.mounts.count(vol.GetId()) != 0 {
+		d.mounts.add(vol.GetId(), name, response.Mountpoint, request.ID)
+		d.logRequest(method, request.Name).Infof(
+			"response %v (%d references)",
+			response.Mountpoint, d.mounts.count(vol.GetId()))
+		json.NewEncoder(w).Encode(&response)
+		return
+	}
 
-	// If this is a block driver, first attach the volume.
-	if v.Type() == api.DriverType_DRIVER_TYPE_BLOCK {
-		// If volume is scaled up, a new volume is created and
-		// vol will change.
-		if vol.Scaled() {
-			vol, err = d.attachScale(method, v, vol, attachOptions)
-		} else {
-			vol, err = d.attachVol(method, v, vol, attachOptions)
-		}
-		if err != nil {
-			d.errorResponse(method, w, err)
-			return
-		}
+	// Volumes restored from a backup cannot be used until the restore completes
+	if err := d.waitRestore(ctx, conn, method, name, vol.GetId()); err != nil {
+		d.errorResponse(method, w, err)
+		return
 	}
 
-	// Note that name is unchanged even if a new volume was created as a
-	// result of scale up.
-	response.Mountpoint = mountpoint
-	os.MkdirAll(mountpoint, 0755)
-	err = v.Mount(vol.Id, response.Mountpoint, nil)
+	// If the volume is scaled, one of its siblings is attached instead,
+	// creating it if needed. Note that the mountpoint is still based on
+	// the name of the scaled volume.
+	attachOptions, err := d.attachOptions(spec, vol)
+	if err != nil {
+		d.errorResponse(method, w, accessError(name, err))
+		return
+	}
+	var attached *api.Volume
+	if vol.GetSpec().GetScale() > 1 {
+		attached, err = d.attachScale(ctx, method, volumes, mountAttach, vol, attachOptions)
+	} else {
+		attached, err = d.attachVol(ctx, method, mountAttach, vol, attachOptions)
+	}
+	if err != nil {
+		d.errorResponse(method, w, accessError(name, err))
+		return
+	}
+	d.mounts.setAttached(vol.GetId(), name, attached.GetId())
+
+	os.MkdirAll(d.sdkMountpath(name), 0755)
+	_, err = mountAttach.Mount(ctx, &api.SdkVolumeMountRequest{
+		VolumeId:  attached.GetId(),
+		MountPath: response.Mountpoint,
+	})
+	sdkMounted := err == nil
+	if err == nil {
+		err = d.bindMount(name, response.Mountpoint)
+	}
 	if err != nil {
 		d.logRequest(method, request.Name).Warnf(
 			"Cannot mount volume %v, %v",
 			response.Mountpoint, err)
-		d.errorResponse(method, w, err)
+
+		// Do not leave the volume attached if we could not mount it, even
+		// if the request was canceled or timed out.
+		detachCtx, cancel := context.WithTimeout(
+			d.tokenContext(context.Background(), request.Name, nil), defaultTimeout)
+		defer cancel()
+		if sdkMounted {
+			mountAttach.Unmount(detachCtx, &api.SdkVolumeUnmountRequest{
+				VolumeId:  attached.GetId(),
+				MountPath: response.Mountpoint,
+			})
+		}
+		if _, err := mountAttach.Detach(detachCtx, &api.SdkVolumeDetachRequest{
+			VolumeId: attached.GetId(),
+		}); err == nil {
+			d.mounts.setAttached(vol.GetId(), name, "")
+		}
+		d.errorResponse(method, w, accessError(name, err))
 		return
 	}
+	d.mounts.add(vol.GetId(), name, response.Mountpoint, request.ID)
 	d.logRequest(method, request.Name).Infof("response %v", response.Mountpoint)
 	json.NewEncoder(w).Encode(&response)
 }
@@ -569,9 +1122,18 @@ func (d *driver) path(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-
 	_, _, _, _, name := d.SpecFromString(request.Name)
-	vol, err := d.volFromName(name)
+	ctx, cancel := d.requestContext(r, method, request.Name, request.Opts)
+	defer cancel()
+
+	conn, err := d.getReadConn(ctx)
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+
+	vol, err := d.inspectVolume(ctx, volumes, name)
 	if err != nil {
 		e := d.volNotFound(method, request.Name, err, w)
 		d.errorResponse(method, w, e)
@@ -579,28 +1141,29 @@ func (d *driver) path(w http.ResponseWriter, r *http.Request) {
 	}
 
 	d.logRequest(method, name).Debugf("")
-	if len(vol.AttachPath) == 0 || len(vol.AttachPath) == 0 {
+	response.Mountpoint = d.volumeMountpoint(ctx, vol)
+	if len(response.Mountpoint) == 0 {
 		e := d.volNotMounted(method, name)
 		d.errorResponse(method, w, e)
 		return
 	}
-	response.Mountpoint = vol.AttachPath[0]
-	response.Mountpoint = path.Join(response.Mountpoint, config.DataDir)
 	d.logRequest(method, request.Name).Debugf("response %v", response.Mountpoint)
 	json.NewEncoder(w).Encode(&response)
 }
 
 func (d *driver) list(w http.ResponseWriter, r *http.Request) {
 	method := "list"
+	ctx, cancel := d.requestContext(r, method, "", nil)
+	defer cancel()
 
-	v, err := volumedrivers.Get(d.name)
+	conn, err := d.getReadConn(ctx)
 	if err != nil {
-		d.logRequest(method, "").Warnf("Cannot locate volume driver: %v", err.Error())
 		d.errorResponse(method, w, err)
 		return
 	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
 
-	vols, err := v.Enumerate(nil, nil)
+	vols, err := d.enumerateVolumes(ctx, volumes, nil)
 	if err != nil {
 		d.errorResponse(method, w, err)
 		return
@@ -608,10 +1171,8 @@ func (d *driver) list(w http.ResponseWriter, r *http.Request) {
 
 	volInfo := make([]volumeInfo, len(vols))
 	for i, v := range vols {
-		volInfo[i].Name = v.Locator.Name
-		if len(v.AttachPath) > 0 || len(v.AttachPath) > 0 {
-			volInfo[i].Mountpoint = path.Join(v.AttachPath[0], config.DataDir)
-		}
+		volInfo[i].Name = v.GetLocator().GetName()
+		volInfo[i].Mountpoint = d.volumeMountpoint(ctx, v)
 	}
 	json.NewEncoder(w).Encode(map[string][]volumeInfo{"Volumes": volInfo})
 }
@@ -630,84 +1191,182 @@ func (d *driver) get(w http.ResponseWriter, r *http.Request) {
 	} else {
 		returnName = name
 	}
-	/*vol, err := d.volFromName(name)
+	ctx, cancel := d.requestContext(r, method, request.Name, request.Opts)
+	defer cancel()
+
+	conn, err := d.getReadConn(ctx)
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+
+	vol, err := d.inspectVolume(ctx, volumes, name)
 	if err != nil {
 		e := d.volNotFound(method, request.Name, err, w)
 		d.errorResponse(method, w, e)
 		return
-	}*/
-
-	volInfo := volumeInfo{Name: returnName}
-	/*if len(vol.AttachPath) > 0 || len(vol.AttachPath) > 0 {
-		volInfo.Mountpoint = path.Join(vol.AttachPath[0], config.DataDir)
-	}*/
+	}
 
+	volInfo := volumeInfo{
+		Name:       returnName,
+		Mountpoint: d.volumeMountpoint(ctx, vol),
+	}
 	json.NewEncoder(w).Encode(map[string]volumeInfo{"Volume": volInfo})
 }
 
 func (d *driver) unmount(w http.ResponseWriter, r *http.Request) {
 	method := "unmount"
 
-	v, err := volumedrivers.Get(d.name)
+	request, err := d.decodeMount(method, w, r)
 	if err != nil {
-		d.logRequest(method, "").Warnf(
-			"Cannot locate volume driver: %v",
-			err.Error())
-		d.errorResponse(method, w, err)
 		return
 	}
+	_, _, _, _, name := d.SpecFromString(request.Name)
+	ctx, cancel := d.requestContext(r, method, request.Name, nil)
+	defer cancel()
 
-	request, err := d.decodeMount(method, w, r)
+	conn, err := d.getLocalConn(ctx)
 	if err != nil {
+		d.errorResponse(method, w, err)
 		return
 	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+	mountAttach := api.NewOpenStorageMountAttachClient(conn)
 
-	_, _, _, _, name := d.SpecFromString(request.Name)
-	vol, err := d.volFromName(name)
+	vol, err := d.inspectVolume(ctx, volumes, name)
 	if err != nil {
 		e := d.volNotFound(method, name, err, w)
 		d.errorResponse(method, w, e)
 		return
 	}
 
+	unlock, err := d.mounts.lockVolume(ctx, vol.GetId())
+	if err != nil {
+		d.errorResponse(method, w, err)
+		return
+	}
+	defer unlock()
+
+	// Only unmount and detach when the last container is done with it
+	referenced := d.mounts.remove(vol.GetId(), request.ID)
+	if refs := d.mounts.count(vol.GetId()); refs != 0 {
+		d.logRequest(method, request.Name).Infof(
+			"volume still has %d references", refs)
+		d.emptyResponse(w)
+		return
+	}
+
+	// Scaled volumes have one of their siblings mounted
 	mountpoint := d.mountpath(name)
-	id := vol.Id
-	if vol.Spec.Scale > 1 {
-		id = v.MountedAt(mountpoint)
-		if len(id) == 0 {
+	id := d.mounts.attached(vol.GetId())
+	if len(id) == 0 {
+		if vol.GetSpec().GetScale() > 1 {
 			err := fmt.Errorf("Failed to find volume mapping for %v",
 				mountpoint)
 			d.logRequest(method, request.Name).Warnf(
 				"Cannot unmount volume %v, %v",
 				mountpoint, err)
-			d.errorResponse(method, w, err)
+			d.errorResponse(method, w, accessError(name, err))
 			return
 		}
+		id = vol.GetId()
 	}
 
-	opts := make(map[string]string)
-	opts[options.OptionsDeleteAfterUnmount] = "true"
-
-	err = v.Unmount(id, mountpoint, opts)
+	err = d.unbindMount(mountpoint)
+	if err == nil {
+		_, err = mountAttach.Unmount(ctx, &api.SdkVolumeUnmountRequest{
+			VolumeId:  id,
+			MountPath: mountpoint,
+			Options: &api.SdkVolumeUnmountOptions{
+				DeleteMountPath: true,
+			},
+		})
+	}
 	if err != nil {
 		d.logRequest(method, request.Name).Warnf(
 			"Cannot unmount volume %v, %v",
 			mountpoint, err)
-		d.errorResponse(method, w, err)
+		if referenced {
+			d.mounts.add(vol.GetId(), name, mountpoint, request.ID)
+		}
+		d.errorResponse(method, w, accessError(name, err))
 		return
 	}
 
-	if v.Type() == api.DriverType_DRIVER_TYPE_BLOCK {
-		_ = v.Detach(id, nil)
+	if _, err = mountAttach.Detach(ctx, &api.SdkVolumeDetachRequest{
+		VolumeId: id,
+	}); err != nil {
+		d.logRequest(method, request.Name).Warnf(
+			"Cannot detach volume %v, %v",
+			id, err)
+	} else {
+		d.mounts.setAttached(vol.GetId(), name, "")
 	}
 	d.emptyResponse(w)
 }
 
+// getScope returns the scope configured for the plugin. When set to auto, the
+// scope is global only if the SDK server provides a cluster with more than
+// one node.
+func (d *driver) getScope(ctx context.Context) (string, error) {
+	if scope := d.options().Scope; scope != ScopeAuto {
+		return scope, nil
+	}
+
+	d.scopeLock.Lock()
+	defer d.scopeLock.Unlock()
+	if len(d.scope) != 0 {
+		return d.scope, nil
+	}
+
+	conn, err := d.getConn(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	caps, err := api.NewOpenStorageIdentityClient(conn).Capabilities(
+		ctx, &api.SdkIdentityCapabilitiesRequest{})
+	if err != nil {
+		return "", err
+	}
+
+	scope := ScopeLocal
+	for _, c := range caps.GetCapabilities() {
+		if c.GetService().GetType() != api.SdkServiceCapability_OpenStorageService_NODE {
+			continue
+		}
+
+		nodes, err := api.NewOpenStorageNodeClient(conn).Enumerate(
+			ctx, &api.SdkNodeEnumerateRequest{})
+		if err != nil {
+			return "", err
+		}
+		if len(nodes.GetNodeIds()) > 1 {
+			scope = ScopeGlobal
+		}
+		break
+	}
+
+	d.scope = scope
+	return d.scope, nil
+}
+
 func (d *driver) capabilities(w http.ResponseWriter, r *http.Request) {
 	method := "capabilities"
 	var response capabilitiesResponse
 
-	response.Capabilities.Scope = "global"
+	ctx, cancel := d.requestContext(r, method, "", nil)
+	defer cancel()
+
+	scope, err := d.getScope(ctx)
+	if err != nil {
+		d.logRequest(method, "").Warnf("Cannot determine scope: %v", err)
+		d.errorResponse(method, w, err)
+		return
+	}
+
+	response.Capabilities.Scope = scope
 	d.logRequest(method, "").Infof("response %v", response.Capabilities.Scope)
 	json.NewEncoder(w).Encode(&response)
 }
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/api/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeVolumes is a volume client serving the volumes from a map of ids to
+// names. Like the SDK server, it matches the names of the enumerate filter
+// as patterns. The volumes of other users are not enumerated and cannot be
+// inspected.
+type fakeVolumes struct {
+	api.OpenStorageVolumeClient
+	volumes map[string]string
+	others  map[string]string
+	deleted []string
+}
+
+func (f *fakeVolumes) EnumerateWithFilters(
+	ctx context.Context,
+	req *api.SdkVolumeEnumerateWithFiltersRequest,
+	opts ...grpc.CallOption,
+) (*api.SdkVolumeEnumerateWithFiltersResponse, error) {
+	ids := make([]string, 0, len(f.volumes))
+	for id, name := range f.volumes {
+		if strings.Contains(name, req.GetLocator().GetName()) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return &api.SdkVolumeEnumerateWithFiltersResponse{VolumeIds: ids}, nil
+}
+
+func (f *fakeVolumes) Inspect(
+	ctx context.Context,
+	req *api.SdkVolumeInspectRequest,
+	opts ...grpc.CallOption,
+) (*api.SdkVolumeInspectResponse, error) {
+	if _, ok := f.others[req.GetVolumeId()]; ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Access denied to volume %s", req.GetVolumeId())
+	}
+	name, ok := f.volumes[req.GetVolumeId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Volume id %s not found", req.GetVolumeId())
+	}
+	return &api.SdkVolumeInspectResponse{
+		Volume: &api.Volume{
+			Id:      req.GetVolumeId(),
+			Locator: &api.VolumeLocator{Name: name},
+		},
+	}, nil
+}
+
+func (f *fakeVolumes) Delete(
+	ctx context.Context,
+	req *api.SdkVolumeDeleteRequest,
+	opts ...grpc.CallOption,
+) (*api.SdkVolumeDeleteResponse, error) {
+	if _, ok := f.volumes[req.GetVolumeId()]; !ok {
+		return nil, status.Errorf(codes.NotFound, "Volume id %s not found", req.GetVolumeId())
+	}
+	delete(f.volumes, req.GetVolumeId())
+	f.deleted = append(f.deleted, req.GetVolumeId())
+	return &api.SdkVolumeDeleteResponse{}, nil
+}
+
+func TestInspectVolume(t *testing.T) {
+	tests := []struct {
+		name     string
+		volumes  map[string]string
+		others   map[string]string
+		volName  string
+		id       string
+		notFound bool
+		err      string
+	}{
+		{
+			name:    "exact match",
+			volumes: map[string]string{"1": "db", "2": "db-backup", "3": "olddb"},
+			volName: "db",
+			id:      "1",
+		},
+		{
+			name:    "id",
+			volumes: map[string]string{"1": "db"},
+			volName: "1",
+			id:      "1",
+		},
+		{
+			name:     "only partial matches",
+			volumes:  map[string]string{"2": "db-backup", "3": "olddb"},
+			volName:  "db",
+			notFound: true,
+		},
+		{
+			name:     "no volumes",
+			volumes:  map[string]string{},
+			volName:  "db",
+			notFound: true,
+		},
+		{
+			name:    "ambiguous",
+			volumes: map[string]string{"1": "db", "2": "db", "3": "db-backup"},
+			volName: "db",
+			err:     "Volume name db is ambiguous, it matches volumes 1, 2",
+		},
+		{
+			name:     "name of a volume of another user",
+			others:   map[string]string{"1": "db"},
+			volName:  "db",
+			notFound: true,
+		},
+		{
+			name:    "id of a volume of another user",
+			others:  map[string]string{"1": "db"},
+			volName: "1",
+			err:     "rpc error: code = PermissionDenied desc = Access denied to volume 1",
+		},
+	}
+
+	d := &driver{}
+	for _, tt := range tests {
+		volumes := &fakeVolumes{volumes: tt.volumes, others: tt.others}
+		vol, err := d.inspectVolume(context.Background(), volumes, tt.volName)
+		switch {
+		case tt.notFound:
+			if _, ok := err.(*errors.ErrNotFound); !ok {
+				t.Errorf("%s: error %v, expected not found", tt.name, err)
+			}
+		case len(tt.err) != 0:
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, expected %q", tt.name, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case vol.GetId() != tt.id:
+			t.Errorf("%s: volume %s, expected %s", tt.name, vol.GetId(), tt.id)
+		}
+	}
+}
+
+func TestVolumeMountpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		vol        *api.Volume
+		mountpoint string
+	}{
+		{
+			name: "mounted for Docker",
+			vol: &api.Volume{
+				Id:         "mounted",
+				AttachedOn: "local",
+				AttachPath: []string{"/var/lib/osd/mounts/db"},
+			},
+			mountpoint: "/var/lib/osd/mounts/db",
+		},
+		{
+			name: "scaled volume mounted for Docker",
+			vol: &api.Volume{
+				Id:   "mounted",
+				Spec: &api.VolumeSpec{Scale: 3},
+			},
+			mountpoint: "/var/lib/osd/mounts/db",
+		},
+		{
+			name: "attached on this node",
+			vol: &api.Volume{
+				Id:         "vol",
+				AttachedOn: "local",
+				AttachPath: []string{"/mnt/vol"},
+			},
+			mountpoint: "/mnt/vol/.data",
+		},
+		{
+			name: "attached on this node address",
+			vol: &api.Volume{
+				Id:         "vol",
+				AttachedOn: "10.0.0.1",
+				AttachPath: []string{"/mnt/vol"},
+			},
+			mountpoint: "/mnt/vol/.data",
+		},
+		{
+			name: "attached on another node",
+			vol: &api.Volume{
+				Id:         "vol",
+				AttachedOn: "remote",
+				AttachPath: []string{"/mnt/vol"},
+			},
+		},
+		{
+			name: "scaled volume not mounted for Docker",
+			vol: &api.Volume{
+				Id:         "vol",
+				AttachedOn: "local",
+				AttachPath: []string{"/mnt/vol"},
+				Spec:       &api.VolumeSpec{Scale: 3},
+			},
+		},
+		{
+			name: "not attached",
+			vol:  &api.Volume{Id: "vol"},
+		},
+	}
+
+	mounts, err := newMountStore("")
+	if err != nil {
+		t.Fatalf("newMountStore: %v", err)
+	}
+	mounts.add("mounted", "db", "/var/lib/osd/mounts/db", "a")
+	d := &driver{
+		mounts: mounts,
+		node:   &api.StorageNode{Id: "local", MgmtIp: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if mountpoint := d.volumeMountpoint(context.Background(), tt.vol); mountpoint != tt.mountpoint {
+			t.Errorf("%s: mountpoint %q, expected %q", tt.name, mountpoint, tt.mountpoint)
+		}
+	}
+}
+
+func TestRemoveVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes map[string]string
+		volName string
+		deleted []string
+		err     string
+	}{
+		{
+			name:    "exact match",
+			volumes: map[string]string{"1": "db", "2": "db-backup", "3": "olddb"},
+			volName: "db",
+			deleted: []string{"1"},
+		},
+		{
+			name:    "id",
+			volumes: map[string]string{"1": "db"},
+			volName: "1",
+			deleted: []string{"1"},
+		},
+		{
+			name:    "ambiguous",
+			volumes: map[string]string{"1": "db", "2": "db"},
+			volName: "db",
+			err:     "Volume name db is ambiguous, it matches volumes 1, 2",
+		},
+		{
+			name:    "already deleted",
+			volumes: map[string]string{"2": "db-backup"},
+			volName: "db",
+		},
+	}
+
+	d := &driver{}
+	for _, tt := range tests {
+		volumes := &fakeVolumes{volumes: tt.volumes}
+		err := d.removeVolume(context.Background(), volumes, tt.volName)
+		switch {
+		case len(tt.err) != 0:
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, expected %q", tt.name, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(volumes.deleted, tt.deleted) {
+			t.Errorf("%s: deleted %v, expected %v", tt.name, volumes.deleted, tt.deleted)
+		}
+
+		// Docker retries removes which it did not see complete
+		if err == nil {
+			if err := d.removeVolume(context.Background(), volumes, tt.volName); err != nil {
+				t.Errorf("%s: retried remove failed: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestIsAccessDenied(t *testing.T) {
+	tests := []struct {
+		err    error
+		denied bool
+	}{
+		{err: status.Error(codes.PermissionDenied, "access denied"), denied: true},
+		{err: status.Error(codes.Unauthenticated, "missing token"), denied: true},
+		{err: status.Error(codes.NotFound, "volume vol")},
+		{err: status.Error(codes.Unavailable, "connection refused")},
+		{err: nil},
+	}
+
+	for _, tt := range tests {
+		if denied := isAccessDenied(tt.err); denied != tt.denied {
+			t.Errorf("%v: denied %v, expected %v", tt.err, denied, tt.denied)
+		}
+	}
+}
+
+func TestVolNotFound(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		message   string
+		unchanged bool
+	}{
+		{
+			name:    "not found",
+			err:     &errors.ErrNotFound{Type: "Volume", ID: "vol"},
+			message: "Failed to locate volume: " + (&errors.ErrNotFound{Type: "Volume", ID: "vol"}).Error(),
+		},
+		{
+			name:    "internal",
+			err:     status.Error(codes.Internal, "failed"),
+			message: "Failed to locate volume: failed",
+		},
+		{
+			name:      "unavailable",
+			err:       status.Error(codes.Unavailable, "connection refused"),
+			message:   "SDK server unavailable, retry later: connection refused",
+			unchanged: true,
+		},
+		{
+			name:      "deadline exceeded",
+			err:       status.Error(codes.DeadlineExceeded, "context deadline exceeded"),
+			message:   "SDK server timed out, retry later: context deadline exceeded",
+			unchanged: true,
+		},
+	}
+
+	d := &driver{}
+	for _, tt := range tests {
+		err := d.volNotFound("mount", "vol", tt.err, nil)
+		if message := translateError(err).Error(); message != tt.message {
+			t.Errorf("%s: message %q, expected %q", tt.name, message, tt.message)
+		}
+		if unchanged := err == tt.err; unchanged != tt.unchanged {
+			t.Errorf("%s: error returned unchanged %v, expected %v", tt.name, unchanged, tt.unchanged)
+		}
+	}
+}
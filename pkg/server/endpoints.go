@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// sdkDiscoveryInterval is how often the SDK endpoints are discovered from
+// the nodes in the cluster.
+const sdkDiscoveryInterval = 1 * time.Minute
+
+// endpointPool holds the connections to the SDK endpoints. The first
+// endpoint is the local node. Volumes are attached and mounted on the node
+// serving the request, so these calls always use the local node. Other
+// calls fail over to the remaining endpoints when the local node is down,
+// and read-only calls are spread across all of them.
+type endpointPool struct {
+	lock       sync.RWMutex
+	static     []*connManager
+	discovered []*connManager
+	next       uint32
+	creds      credentials.TransportCredentials
+	insecure   bool
+	closed     bool
+	// warned are the endpoints already warned about, since discovery
+	// would repeat the warnings every minute
+	warned map[string]bool
+}
+
+// newEndpointPool returns a pool for the comma separated list of endpoints.
+// The connections use TLS if creds is not nil. Without TLS, endpoints which
+// are not on this host are refused unless insecure is set.
+func newEndpointPool(
+	endpoints string,
+	creds credentials.TransportCredentials,
+	insecure bool,
+) (*endpointPool, error) {
+	p := &endpointPool{
+		creds:    creds,
+		insecure: insecure,
+		warned:   make(map[string]bool),
+	}
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if len(endpoint) == 0 {
+			continue
+		}
+		if err := p.checkEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+		p.static = append(p.static, newConnManager(endpoint, p.creds))
+	}
+	if len(p.static) == 0 {
+		return nil, fmt.Errorf("No SDK endpoint provided")
+	}
+	return p, nil
+}
+
+// checkEndpoint returns an error if tokens cannot be sent to endpoint, see
+// checkPlaintext, and warns when they are sent in plaintext.
+func (p *endpointPool) checkEndpoint(endpoint string) error {
+	if err := checkPlaintext(endpoint, p.creds, p.insecure); err != nil {
+		return err
+	}
+	if sendsPlaintext(endpoint, p.creds) {
+		p.warnOnce(endpoint, "TLS is not configured, tokens are sent to SDK endpoint %s in plaintext",
+			endpoint)
+	}
+	return nil
+}
+
+// warnOnce logs the warning about endpoint, unless one was already logged
+func (p *endpointPool) warnOnce(endpoint string, format string, args ...interface{}) {
+	if p.warned[endpoint] {
+		return
+	}
+	p.warned[endpoint] = true
+	logrus.Warnf(format, args...)
+}
+
+// all returns the connections of all the endpoints, local node first
+func (p *endpointPool) all() []*connManager {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	conns := make([]*connManager, 0, len(p.static)+len(p.discovered))
+	conns = append(conns, p.static...)
+	return append(conns, p.discovered...)
+}
+
+// Local returns a connection to the local node
+func (p *endpointPool) Local(ctx context.Context) (*grpc.ClientConn, error) {
+	return p.static[0].Conn(ctx)
+}
+
+// Failover returns a connection to the first available endpoint
+func (p *endpointPool) Failover(ctx context.Context) (*grpc.ClientConn, error) {
+	return p.first(ctx, p.all())
+}
+
+// Spread returns a connection to an available endpoint, taking turns between
+// the endpoints for each call.
+func (p *endpointPool) Spread(ctx context.Context) (*grpc.ClientConn, error) {
+	return p.first(ctx, p.rotated())
+}
+
+// rotated returns the connections of all the endpoints, starting with the
+// next endpoint in turn.
+func (p *endpointPool) rotated() []*connManager {
+	conns := p.all()
+	start := int(atomic.AddUint32(&p.next, 1) % uint32(len(conns)))
+	rotated := make([]*connManager, 0, len(conns))
+	rotated = append(rotated, conns[start:]...)
+	return append(rotated, conns[:start]...)
+}
+
+// first returns a connection to the first endpoint of conns which is ready.
+// If none is ready yet, it waits for each endpoint in turn.
+func (p *endpointPool) first(ctx context.Context, conns []*connManager) (*grpc.ClientConn, error) {
+	for _, c := range conns {
+		conn, err := c.current()
+		if err == nil && conn.GetState() == connectivity.Ready {
+			return conn, nil
+		}
+	}
+
+	errs := make([]string, 0, len(conns))
+	for _, c := range conns {
+		conn, err := c.Conn(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, status.Convert(err).Message())
+	}
+	return nil, status.Errorf(codes.Unavailable, "No SDK server is available: %s",
+		strings.Join(errs, "; "))
+}
+
+// update replaces the discovered endpoints. Connections to endpoints which
+// are no longer in the list are closed.
+func (p *endpointPool) update(endpoints []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	known := make(map[string]*connManager)
+	for _, c := range p.discovered {
+		known[c.endpoint] = c
+	}
+	for _, c := range p.static {
+		known[c.endpoint] = nil
+	}
+
+	discovered := make([]*connManager, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		c, ok := known[endpoint]
+		if ok && c == nil {
+			// Static endpoint or duplicate
+			continue
+		}
+		if !ok {
+			if err := p.checkEndpoint(endpoint); err != nil {
+				p.warnOnce(endpoint, "Ignoring discovered endpoint: %v", err)
+				known[endpoint] = nil
+				continue
+			}
+			c = newConnManager(endpoint, p.creds)
+		}
+		discovered = append(discovered, c)
+		known[endpoint] = nil
+	}
+
+	for _, c := range p.discovered {
+		if known[c.endpoint] != nil {
+			c.Close()
+		}
+	}
+	p.discovered = discovered
+}
+
+func (p *endpointPool) isClosed() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.closed
+}
+
+// Close closes the connections to all the endpoints
+func (p *endpointPool) Close() error {
+	p.lock.Lock()
+	p.closed = true
+	conns := append(append([]*connManager{}, p.static...), p.discovered...)
+	p.lock.Unlock()
+
+	var err error
+	for _, c := range conns {
+		if e := c.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// sdkPort returns the port of the first static endpoint using TCP
+func (p *endpointPool) sdkPort() (string, error) {
+	for _, c := range p.static {
+		if isUnixEndpoint(c.endpoint) {
+			continue
+		}
+		_, port, err := net.SplitHostPort(c.endpoint)
+		if err == nil {
+			return port, nil
+		}
+	}
+	return "", fmt.Errorf("No SDK endpoint with a TCP port to discover the other nodes")
+}
+
+// discoverEndpoints periodically adds the SDK endpoints of the nodes in the
+// cluster to the pool.
+func (d *driver) discoverEndpoints() {
+	method := "discover"
+
+	port, err := d.sdk.sdkPort()
+	if err != nil {
+		d.logRequest(method, "").Warnf("Cannot discover SDK endpoints: %v", err)
+		return
+	}
+
+	for !d.sdk.isClosed() {
+		endpoints, err := d.nodeEndpoints(port)
+		if err != nil {
+			d.logRequest(method, "").Warnf("Failed to discover SDK endpoints: %v", err)
+		} else {
+			d.logRequest(method, "").Debugf("SDK endpoints: %v", endpoints)
+			d.sdk.update(endpoints)
+		}
+		time.Sleep(sdkDiscoveryInterval)
+	}
+}
+
+// nodeEndpoints returns the SDK endpoints of the other nodes that are up
+func (d *driver) nodeEndpoints(port string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	ctx = d.tokenContext(ctx, "", nil)
+	local, err := d.localNode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect the local node: %v", err)
+	}
+	conn, err := d.sdk.Failover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return otherNodeEndpoints(ctx, api.NewOpenStorageNodeClient(conn), local.GetId(), port)
+}
+
+// otherNodeEndpoints returns the SDK endpoints of the nodes that are up,
+// except the local node, which is already the first static endpoint.
+func otherNodeEndpoints(
+	ctx context.Context,
+	nodes api.OpenStorageNodeClient,
+	localID, port string,
+) ([]string, error) {
+	enumResp, err := nodes.Enumerate(ctx, &api.SdkNodeEnumerateRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0, len(enumResp.GetNodeIds()))
+	for _, id := range enumResp.GetNodeIds() {
+		if id == localID {
+			continue
+		}
+		inspResp, err := nodes.Inspect(ctx, &api.SdkNodeInspectRequest{NodeId: id})
+		if err != nil {
+			return nil, err
+		}
+		node := inspResp.GetNode()
+		if node.GetStatus() != api.Status_STATUS_OK || len(node.GetMgmtIp()) == 0 {
+			continue
+		}
+		endpoints = append(endpoints, net.JoinHostPort(node.GetMgmtIp(), port))
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// poolEndpoints returns the endpoints of the connections
+func poolEndpoints(conns []*connManager) []string {
+	endpoints := make([]string, 0, len(conns))
+	for _, c := range conns {
+		endpoints = append(endpoints, c.endpoint)
+	}
+	return endpoints
+}
+
+func TestNewEndpointPool(t *testing.T) {
+	tests := []struct {
+		endpoints string
+		expected  []string
+		fails     bool
+	}{
+		{
+			endpoints: "localhost:9100",
+			expected:  []string{"localhost:9100"},
+		},
+		{
+			endpoints: " localhost:9100, ,10.0.0.2:9100,",
+			expected:  []string{"localhost:9100", "10.0.0.2:9100"},
+		},
+		{
+			endpoints: " , ",
+			fails:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := newEndpointPool(tt.endpoints, nil, true)
+		if fails := err != nil; fails != tt.fails {
+			t.Errorf("%q: error %v, expected failure %v", tt.endpoints, err, tt.fails)
+			continue
+		}
+		if tt.fails {
+			continue
+		}
+		if endpoints := poolEndpoints(p.all()); !reflect.DeepEqual(endpoints, tt.expected) {
+			t.Errorf("%q: endpoints %v, expected %v", tt.endpoints, endpoints, tt.expected)
+		}
+	}
+}
+
+func TestEndpointPoolUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		discovered []string
+		expected   []string
+	}{
+		{
+			name:       "discovered nodes",
+			discovered: []string{"10.0.0.2:9100", "10.0.0.3:9100"},
+			expected:   []string{"localhost:9100", "10.0.0.2:9100", "10.0.0.3:9100"},
+		},
+		{
+			name:       "duplicates and static endpoints",
+			discovered: []string{"10.0.0.3:9100", "localhost:9100", "10.0.0.3:9100", "10.0.0.2:9100"},
+			expected:   []string{"localhost:9100", "10.0.0.3:9100", "10.0.0.2:9100"},
+		},
+		{
+			name:       "removed node",
+			discovered: []string{"10.0.0.2:9100"},
+			expected:   []string{"localhost:9100", "10.0.0.2:9100"},
+		},
+		{
+			name:     "no nodes",
+			expected: []string{"localhost:9100"},
+		},
+	}
+
+	p, err := newEndpointPool("localhost:9100", nil, true)
+	if err != nil {
+		t.Fatalf("newEndpointPool: %v", err)
+	}
+	for _, tt := range tests {
+		before := p.discovered
+		p.update(tt.discovered)
+
+		if endpoints := poolEndpoints(p.all()); !reflect.DeepEqual(endpoints, tt.expected) {
+			t.Errorf("%s: endpoints %v, expected %v", tt.name, endpoints, tt.expected)
+		}
+
+		// Kept endpoints reuse their connection, removed ones are closed
+		kept := make(map[*connManager]bool)
+		for _, c := range p.discovered {
+			kept[c] = true
+			if c.closed {
+				t.Errorf("%s: endpoint %s is closed", tt.name, c.endpoint)
+			}
+		}
+		for _, c := range before {
+			if !kept[c] && !c.closed {
+				t.Errorf("%s: removed endpoint %s is not closed", tt.name, c.endpoint)
+			}
+			if kept[c] != contains(tt.discovered, c.endpoint) {
+				t.Errorf("%s: connection to %s not reused", tt.name, c.endpoint)
+			}
+		}
+	}
+	if p.static[0].closed {
+		t.Errorf("static endpoint closed by the updates")
+	}
+}
+
+func TestEndpointPoolRotated(t *testing.T) {
+	p, err := newEndpointPool("a:9100,b:9100", nil, true)
+	if err != nil {
+		t.Fatalf("newEndpointPool: %v", err)
+	}
+	p.update([]string{"c:9100"})
+
+	// Each call starts with the next endpoint, keeping the same order
+	expected := [][]string{
+		{"b:9100", "c:9100", "a:9100"},
+		{"c:9100", "a:9100", "b:9100"},
+		{"a:9100", "b:9100", "c:9100"},
+		{"b:9100", "c:9100", "a:9100"},
+	}
+	for i, e := range expected {
+		if endpoints := poolEndpoints(p.rotated()); !reflect.DeepEqual(endpoints, e) {
+			t.Errorf("call %d: endpoints %v, expected %v", i, endpoints, e)
+		}
+	}
+}
+
+func TestSDKPort(t *testing.T) {
+	tests := []struct {
+		endpoints string
+		port      string
+		fails     bool
+	}{
+		{endpoints: "localhost:9100", port: "9100"},
+		{endpoints: "unix:///run/osd.sock,10.0.0.2:9110", port: "9110"},
+		{endpoints: "/run/osd.sock", fails: true},
+	}
+
+	for _, tt := range tests {
+		p, err := newEndpointPool(tt.endpoints, nil, true)
+		if err != nil {
+			t.Fatalf("%q: newEndpointPool: %v", tt.endpoints, err)
+		}
+		port, err := p.sdkPort()
+		if fails := err != nil; fails != tt.fails || port != tt.port {
+			t.Errorf("%q: port %q error %v, expected %q failure %v",
+				tt.endpoints, port, err, tt.port, tt.fails)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEndpointPoolPlaintext(t *testing.T) {
+	if _, err := newEndpointPool("localhost:9100,10.0.0.2:9100", nil, false); err == nil {
+		t.Errorf("plaintext endpoint off this host accepted without insecure")
+	}
+
+	p, err := newEndpointPool("localhost:9100", nil, false)
+	if err != nil {
+		t.Fatalf("newEndpointPool: %v", err)
+	}
+	p.update([]string{"10.0.0.2:9100", "127.0.0.2:9100"})
+	expected := []string{"localhost:9100", "127.0.0.2:9100"}
+	if endpoints := poolEndpoints(p.all()); !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("endpoints %v, expected %v", endpoints, expected)
+	}
+}
+
+func TestEndpointPoolWarnOnce(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	p, err := newEndpointPool("localhost:9100", nil, false)
+	if err != nil {
+		t.Fatalf("newEndpointPool: %v", err)
+	}
+	p.update([]string{"10.0.0.2:9100"})
+	p.update([]string{"10.0.0.2:9100"})
+	if n := strings.Count(buf.String(), "Ignoring discovered endpoint"); n != 1 {
+		t.Errorf("refused endpoint warned %d times, expected once", n)
+	}
+
+	buf.Reset()
+	p, err = newEndpointPool("localhost:9100", nil, true)
+	if err != nil {
+		t.Fatalf("newEndpointPool: %v", err)
+	}
+	defer p.Close()
+	p.update([]string{"10.0.0.2:9100"})
+	p.update(nil)
+	p.update([]string{"10.0.0.2:9100"})
+	if n := strings.Count(buf.String(), "in plaintext"); n != 1 {
+		t.Errorf("plaintext endpoint warned %d times, expected once", n)
+	}
+}
+
+// fakeNodes is a node client serving the nodes from a map of ids to nodes
+type fakeNodes struct {
+	api.OpenStorageNodeClient
+	nodes map[string]*api.StorageNode
+}
+
+func (f *fakeNodes) Enumerate(
+	ctx context.Context,
+	req *api.SdkNodeEnumerateRequest,
+	opts ...grpc.CallOption,
+) (*api.SdkNodeEnumerateResponse, error) {
+	ids := make([]string, 0, len(f.nodes))
+	for id := range f.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return &api.SdkNodeEnumerateResponse{NodeIds: ids}, nil
+}
+
+func (f *fakeNodes) Inspect(
+	ctx context.Context,
+	req *api.SdkNodeInspectRequest,
+	opts ...grpc.CallOption,
+) (*api.SdkNodeInspectResponse, error) {
+	node, ok := f.nodes[req.GetNodeId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Node %s not found", req.GetNodeId())
+	}
+	return &api.SdkNodeInspectResponse{Node: node}, nil
+}
+
+func TestOtherNodeEndpoints(t *testing.T) {
+	nodes := &fakeNodes{nodes: map[string]*api.StorageNode{
+		"1": {Id: "1", MgmtIp: "10.0.0.1", Status: api.Status_STATUS_OK},
+		"2": {Id: "2", MgmtIp: "10.0.0.2", Status: api.Status_STATUS_OK},
+		"3": {Id: "3", MgmtIp: "10.0.0.3", Status: api.Status_STATUS_OFFLINE},
+		"4": {Id: "4", Status: api.Status_STATUS_OK},
+		"5": {Id: "5", MgmtIp: "10.0.0.5", Status: api.Status_STATUS_OK},
+	}}
+
+	endpoints, err := otherNodeEndpoints(context.Background(), nodes, "1", "9100")
+	if err != nil {
+		t.Fatalf("otherNodeEndpoints: %v", err)
+	}
+	expected := []string{"10.0.0.2:9100", "10.0.0.5:9100"}
+	if !reflect.DeepEqual(endpoints, expected) {
+		t.Errorf("endpoints %v, expected %v", endpoints, expected)
+	}
+}
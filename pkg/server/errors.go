@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	ost_errors "github.com/libopenstorage/openstorage/api/errors"
+	"github.com/libopenstorage/openstorage/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError is an error returned by the SDK server, with a message
+// readable by Docker users and the HTTP status code matching the gRPC code.
+type statusError struct {
+	code    codes.Code
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// statusErrors maps the gRPC codes to a prefix for the message and to an
+// HTTP status code. Codes which are not listed are internal errors.
+var statusErrors = map[codes.Code]struct {
+	prefix     string
+	httpStatus int
+}{
+	codes.InvalidArgument:    {"Invalid request", http.StatusBadRequest},
+	codes.NotFound:           {"Not found", http.StatusNotFound},
+	codes.AlreadyExists:      {"Already exists", http.StatusConflict},
+	codes.PermissionDenied:   {"Permission denied", http.StatusForbidden},
+	codes.Unauthenticated:    {"Authentication required", http.StatusUnauthorized},
+	codes.FailedPrecondition: {"Cannot be done in the current state", http.StatusPreconditionFailed},
+	codes.Unimplemented:      {"Not supported by the SDK server", http.StatusNotImplemented},
+	codes.Unavailable:        {"SDK server unavailable, retry later", http.StatusServiceUnavailable},
+	codes.DeadlineExceeded:   {"SDK server timed out, retry later", http.StatusGatewayTimeout},
+	codes.Canceled:           {"Request canceled", http.StatusServiceUnavailable},
+}
+
+// translateError returns a readable error for errors returned by the SDK
+// server. Other errors are returned unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*statusError); ok {
+		return err
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if e, ok := statusErrors[s.Code()]; ok {
+		return &statusError{code: s.Code(), message: fmt.Sprintf("%s: %s", e.prefix, s.Message())}
+	}
+	return &statusError{code: s.Code(), message: s.Message()}
+}
+
+// contextError returns the status of an error returned by a context
+func contextError(err error) error {
+	switch err {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
+
+// httpStatus returns the HTTP status code for err
+func httpStatus(err error) int {
+	code := codes.Unknown
+	switch e := err.(type) {
+	case *statusError:
+		code = e.code
+	case *ost_errors.ErrNotFound:
+		code = codes.NotFound
+	case *ost_errors.ErrExists:
+		code = codes.AlreadyExists
+	default:
+		if err == volume.ErrDriverInitializing {
+			code = codes.Unavailable
+		} else if s, ok := status.FromError(err); ok {
+			code = s.Code()
+		}
+	}
+
+	if e, ok := statusErrors[code]; ok {
+		return e.httpStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// sendStatusError sends err with the HTTP status code matching it
+func (rest *restBase) sendStatusError(request string, id string, w http.ResponseWriter, err error) {
+	rest.sendError(request, id, w, translateError(err).Error(), httpStatus(err))
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	ost_errors "github.com/libopenstorage/openstorage/api/errors"
+	"github.com/libopenstorage/openstorage/volume"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		message    string
+		httpStatus int
+	}{
+		{
+			name:       "invalid argument",
+			err:        status.Error(codes.InvalidArgument, "size must be set"),
+			message:    "Invalid request: size must be set",
+			httpStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not found",
+			err:        status.Error(codes.NotFound, "volume vol"),
+			message:    "Not found: volume vol",
+			httpStatus: http.StatusNotFound,
+		},
+		{
+			name:       "already exists",
+			err:        status.Error(codes.AlreadyExists, "volume vol"),
+			message:    "Already exists: volume vol",
+			httpStatus: http.StatusConflict,
+		},
+		{
+			name:       "permission denied",
+			err:        status.Error(codes.PermissionDenied, "access denied"),
+			message:    "Permission denied: access denied",
+			httpStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unauthenticated",
+			err:        status.Error(codes.Unauthenticated, "missing token"),
+			message:    "Authentication required: missing token",
+			httpStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "failed precondition",
+			err:        status.Error(codes.FailedPrecondition, "volume is attached"),
+			message:    "Cannot be done in the current state: volume is attached",
+			httpStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "unimplemented",
+			err:        status.Error(codes.Unimplemented, "not supported"),
+			message:    "Not supported by the SDK server: not supported",
+			httpStatus: http.StatusNotImplemented,
+		},
+		{
+			name:       "unavailable",
+			err:        status.Error(codes.Unavailable, "connection refused"),
+			message:    "SDK server unavailable, retry later: connection refused",
+			httpStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "deadline exceeded",
+			err:        status.Error(codes.DeadlineExceeded, "context deadline exceeded"),
+			message:    "SDK server timed out, retry later: context deadline exceeded",
+			httpStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "internal",
+			err:        status.Error(codes.Internal, "failed"),
+			message:    "failed",
+			httpStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "not a status",
+			err:        fmt.Errorf("other error"),
+			message:    "other error",
+			httpStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "translated twice",
+			err:        translateError(status.Error(codes.NotFound, "volume vol")),
+			message:    "Not found: volume vol",
+			httpStatus: http.StatusNotFound,
+		},
+		{
+			name:       "openstorage not found",
+			err:        &ost_errors.ErrNotFound{Type: "Volume", ID: "vol"},
+			message:    (&ost_errors.ErrNotFound{Type: "Volume", ID: "vol"}).Error(),
+			httpStatus: http.StatusNotFound,
+		},
+		{
+			name:       "openstorage exists",
+			err:        &ost_errors.ErrExists{Type: "Volume", ID: "vol"},
+			message:    (&ost_errors.ErrExists{Type: "Volume", ID: "vol"}).Error(),
+			httpStatus: http.StatusConflict,
+		},
+		{
+			name:       "driver initializing",
+			err:        volume.ErrDriverInitializing,
+			message:    volume.ErrDriverInitializing.Error(),
+			httpStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		if message := translateError(tt.err).Error(); message != tt.message {
+			t.Errorf("%s: message %q, expected %q", tt.name, message, tt.message)
+		}
+		if code := httpStatus(tt.err); code != tt.httpStatus {
+			t.Errorf("%s: HTTP status %d, expected %d", tt.name, code, tt.httpStatus)
+		}
+	}
+
+	if err := translateError(nil); err != nil {
+		t.Errorf("nil error translated to %v", err)
+	}
+}
@@ -29,7 +29,7 @@ func (vd *volAPI) cloudMigrateStart(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
-		vd.sendError(method, startReq.TargetId, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, startReq.TargetId, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(response)
@@ -52,7 +52,7 @@ func (vd *volAPI) cloudMigrateCancel(w http.ResponseWriter, r *http.Request) {
 
 	err = d.CloudMigrateCancel(cancelReq)
 	if err != nil {
-		vd.sendError(method, cancelReq.TaskId, w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, cancelReq.TaskId, w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -69,7 +69,7 @@ func (vd *volAPI) cloudMigrateStatus(w http.ResponseWriter, r *http.Request) {
 
 	statusResp, err := d.CloudMigrateStatus()
 	if err != nil {
-		vd.sendError(method, "", w, err.Error(), http.StatusInternalServerError)
+		vd.sendStatusError(method, "", w, err)
 		return
 	}
 
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	mountStateFile = "mounts.json"
+
+	// reconcileRetryMax is the longest wait between two attempts to
+	// reconcile the mount state
+	reconcileRetryMax = time.Minute
+)
+
+// volumeMounts is the state of a volume used by Docker on this node
+type volumeMounts struct {
+	Name       string
+	Mountpoint string
+	// AttachedID is the id of the volume attached and mounted for Docker. It
+	// is empty if nothing is attached, and it is the id of one of the
+	// siblings for scaled volumes.
+	AttachedID string
+	MountIDs   map[string]struct{}
+}
+
+// mountStore tracks the Docker mount ids referencing each volume so that a
+// volume shared by multiple containers is attached and mounted only once.
+// Callers must hold the lock of the volume, see lockVolume, while checking
+// or updating its references and while attaching, mounting, unmounting or
+// detaching it. The lock of the store only protects the state itself.
+//
+// If a state directory is provided, the state is saved to disk on every
+// change so that it survives a restart of the gateway.
+type mountStore struct {
+	lock    sync.Mutex
+	file    string
+	volumes map[string]*volumeMounts
+
+	locksLock   sync.Mutex
+	volumeLocks map[string]*volumeLock
+}
+
+// volumeLock serializes the operations on one volume
+type volumeLock struct {
+	ch    chan struct{}
+	users int
+}
+
+// newMountStore returns a mount store saved in stateDir. If stateDir is
+// empty the state is only kept in memory.
+func newMountStore(stateDir string) (*mountStore, error) {
+	m := &mountStore{
+		volumes:     make(map[string]*volumeMounts),
+		volumeLocks: make(map[string]*volumeLock),
+	}
+	if len(stateDir) == 0 {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, err
+	}
+	m.file = path.Join(stateDir, mountStateFile)
+	data, err := ioutil.ReadFile(m.file)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.volumes); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// lockVolume waits until no other request uses the volume, or until ctx
+// expires. It returns the function releasing the lock.
+func (m *mountStore) lockVolume(ctx context.Context, volumeID string) (func(), error) {
+	m.locksLock.Lock()
+	l, ok := m.volumeLocks[volumeID]
+	if !ok {
+		l = &volumeLock{ch: make(chan struct{}, 1)}
+		m.volumeLocks[volumeID] = l
+	}
+	l.users++
+	m.locksLock.Unlock()
+
+	release := func() {
+		m.locksLock.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(m.volumeLocks, volumeID)
+		}
+		m.locksLock.Unlock()
+	}
+
+	select {
+	case l.ch <- struct{}{}:
+		return func() {
+			<-l.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// save writes the state to disk, replacing the previous state atomically.
+// The caller must hold the lock of the store.
+func (m *mountStore) save() {
+	if len(m.file) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(m.volumes)
+	if err != nil {
+		logrus.Errorf("Failed to encode mount state: %v", err)
+		return
+	}
+	tmp := m.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		logrus.Errorf("Failed to save mount state to %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, m.file); err != nil {
+		logrus.Errorf("Failed to save mount state to %s: %v", m.file, err)
+	}
+}
+
+func (m *mountStore) get(volumeID, name string) *volumeMounts {
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		v = &volumeMounts{
+			Name:     name,
+			MountIDs: make(map[string]struct{}),
+		}
+		m.volumes[volumeID] = v
+	}
+	return v
+}
+
+// forget removes the volume from the store if it is no longer in use
+func (m *mountStore) forget(volumeID string) {
+	if v, ok := m.volumes[volumeID]; ok && len(v.AttachedID) == 0 && len(v.MountIDs) == 0 {
+		delete(m.volumes, volumeID)
+	}
+}
+
+// ids returns the ids of the volumes in the store
+func (m *mountStore) ids() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ids := make([]string, 0, len(m.volumes))
+	for id := range m.volumes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// state returns a copy of the state of the volume
+func (m *mountStore) state(volumeID string) (volumeMounts, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		return volumeMounts{}, false
+	}
+	state := *v
+	state.MountIDs = make(map[string]struct{}, len(v.MountIDs))
+	for id := range v.MountIDs {
+		state.MountIDs[id] = struct{}{}
+	}
+	return state, true
+}
+
+// count returns the number of mount ids referencing the volume
+func (m *mountStore) count(volumeID string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if v, ok := m.volumes[volumeID]; ok {
+		return len(v.MountIDs)
+	}
+	return 0
+}
+
+// mountpoint returns where the volume is mounted for Docker
+func (m *mountStore) mountpoint(volumeID string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if v, ok := m.volumes[volumeID]; ok {
+		return v.Mountpoint
+	}
+	return ""
+}
+
+// add saves the mount id as a reference to the volume mounted on mountpoint
+func (m *mountStore) add(volumeID, name, mountpoint, mountID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	v := m.get(volumeID, name)
+	v.Mountpoint = mountpoint
+	v.MountIDs[mountID] = struct{}{}
+	m.save()
+}
+
+// remove deletes the mount id from the references to the volume. It returns
+// false if the mount id was not referencing the volume.
+func (m *mountStore) remove(volumeID, mountID string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		return false
+	}
+	if _, ok = v.MountIDs[mountID]; !ok {
+		return false
+	}
+	delete(v.MountIDs, mountID)
+	if len(v.MountIDs) == 0 {
+		v.Mountpoint = ""
+	}
+	m.forget(volumeID)
+	m.save()
+	return true
+}
+
+// clear deletes all the references to the volume
+func (m *mountStore) clear(volumeID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if v, ok := m.volumes[volumeID]; ok {
+		v.MountIDs = make(map[string]struct{})
+		v.Mountpoint = ""
+		m.forget(volumeID)
+		m.save()
+	}
+}
+
+// delete removes the volume from the store
+func (m *mountStore) delete(volumeID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.volumes[volumeID]; ok {
+		delete(m.volumes, volumeID)
+		m.save()
+	}
+}
+
+// setAttached records the id of the volume attached to this node on behalf
+// of the volume. An empty attachedID records that nothing is attached.
+func (m *mountStore) setAttached(volumeID, name, attachedID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.get(volumeID, name).AttachedID = attachedID
+	m.forget(volumeID)
+	m.save()
+}
+
+// attached returns the id of the volume attached on behalf of the volume
+func (m *mountStore) attached(volumeID string) string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if v, ok := m.volumes[volumeID]; ok {
+		return v.AttachedID
+	}
+	return ""
+}
+
+// reconcileMounts checks the saved mount state against the volumes known to
+// the SDK server and the mounts on this node, and fixes any differences:
+//   - Volumes which no longer exist are removed from the state.
+//   - Volumes which are no longer mounted on this node lose their references,
+//     and are unmounted and detached in the SDK server.
+//   - Volumes left attached without references are detached.
+//
+// The SDK server may start after the gateway, so it retries until all the
+// volumes are reconciled or the plugin is shut down.
+//
+// There is no request to take a token from, so the default token is used. It
+// must be allowed to inspect, unmount and detach the volumes of all users.
+func (d *driver) reconcileMounts() {
+	method := "reconcile"
+
+	// Volumes which the default token cannot access are only reported once
+	denied := make(map[string]bool)
+	retry := time.Second
+	for !d.sdk.isClosed() {
+		err := d.reconcileMountsOnce(denied)
+		if err == nil {
+			return
+		}
+		d.logRequest(method, "").Warnf(
+			"Cannot reconcile mount state, retrying in %v: %v", retry, err)
+		time.Sleep(retry)
+		if retry *= 2; retry > reconcileRetryMax {
+			retry = reconcileRetryMax
+		}
+	}
+}
+
+// reconcileMountsOnce reconciles the volumes in the mount state. It returns
+// an error if any of them could not be reconciled. Volumes which the default
+// token cannot access are added to denied and skipped, since retrying cannot
+// succeed: they keep their state until Docker unmounts them.
+func (d *driver) reconcileMountsOnce(denied map[string]bool) error {
+	method := "reconcile"
+
+	ids := d.mounts.ids()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	mounted := make(map[string]bool)
+	infos, err := mount.GetMounts()
+	if err != nil {
+		return fmt.Errorf("Cannot read the mount table: %v", err)
+	}
+	for _, info := range infos {
+		mounted[info.Mountpoint] = true
+	}
+
+	failed := make([]string, 0)
+	for _, id := range ids {
+		if denied[id] {
+			continue
+		}
+		err := d.reconcileVolume(id, mounted)
+		if isAccessDenied(err) {
+			d.logRequest(method, "").Warnf("Skipping volume %s, the default token "+
+				"cannot access it: %v", id, err)
+			denied[id] = true
+		} else if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", id, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("Failed to reconcile volumes %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// reconcileVolume reconciles the mount state of one volume, holding the lock
+// of the volume for at most the mount timeout.
+func (d *driver) reconcileVolume(id string, mounted map[string]bool) error {
+	method := "reconcile"
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout("mount"))
+	defer cancel()
+	ctx = d.tokenContext(ctx, "", nil)
+
+	unlock, err := d.mounts.lockVolume(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	v, ok := d.mounts.state(id)
+	if !ok {
+		return nil
+	}
+
+	conn, err := d.getLocalConn(ctx)
+	if err != nil {
+		return err
+	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+	mountAttach := api.NewOpenStorageMountAttachClient(conn)
+
+	_, err = volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+		VolumeId: id,
+	})
+	if isNotFound(err) {
+		d.logRequest(method, v.Name).Infof("Volume %s no longer exists", id)
+		d.mounts.delete(id)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if len(v.MountIDs) != 0 && !mounted[v.Mountpoint] {
+		d.logRequest(method, v.Name).Infof(
+			"Volume %s is no longer mounted on %s", id, v.Mountpoint)
+		if len(v.AttachedID) != 0 {
+			if _, err := mountAttach.Unmount(ctx, &api.SdkVolumeUnmountRequest{
+				VolumeId:  v.AttachedID,
+				MountPath: v.Mountpoint,
+			}); err != nil && !isNotFound(err) {
+				return status.Errorf(status.Code(err), "Cannot unmount volume %s: %v", v.AttachedID, err)
+			}
+		}
+		d.mounts.clear(id)
+		v.MountIDs = nil
+	}
+
+	if len(v.MountIDs) == 0 && len(v.AttachedID) != 0 {
+		if _, err := mountAttach.Detach(ctx, &api.SdkVolumeDetachRequest{
+			VolumeId: v.AttachedID,
+		}); err != nil && !isNotFound(err) {
+			return status.Errorf(status.Code(err), "Cannot detach volume %s: %v", v.AttachedID, err)
+		}
+		d.mounts.setAttached(id, v.Name, "")
+	}
+	return nil
+}
+
+// sdkMountpath returns where the gateway sees the directory in which the SDK
+// server mounts the volume
+func (d *driver) sdkMountpath(name string) string {
+	if hostMounts := d.options().HostMounts; len(hostMounts) != 0 {
+		return path.Join(hostMounts, name)
+	}
+	return d.mountpath(name)
+}
+
+// bindMount makes the volume mounted by the SDK server visible on the
+// mountpoint returned to Docker, when the gateway does not see the host
+// mounts there.
+func (d *driver) bindMount(name, mountpoint string) error {
+	source := d.sdkMountpath(name)
+	if source == mountpoint {
+		return nil
+	}
+	os.MkdirAll(mountpoint, 0755)
+	if err := mount.Mount(source, mountpoint, "none", "bind"); err != nil {
+		return fmt.Errorf("Cannot bind mount %s on %s: %v", source, mountpoint, err)
+	}
+	return nil
+}
+
+// unbindMount removes the bind mount made by bindMount, if any
+func (d *driver) unbindMount(mountpoint string) error {
+	if len(d.options().HostMounts) == 0 {
+		return nil
+	}
+	if mounted, err := mount.Mounted(mountpoint); err != nil || !mounted {
+		return err
+	}
+	if err := mount.Unmount(mountpoint); err != nil {
+		return fmt.Errorf("Cannot unmount %s: %v", mountpoint, err)
+	}
+	os.Remove(mountpoint)
+	return nil
+}
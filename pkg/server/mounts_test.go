@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMountStoreReferences(t *testing.T) {
+	type op struct {
+		add     bool
+		mountID string
+		removed bool
+		count   int
+	}
+	tests := []struct {
+		name string
+		ops  []op
+	}{
+		{
+			name: "single mount",
+			ops: []op{
+				{add: true, mountID: "a", count: 1},
+				{mountID: "a", removed: true, count: 0},
+			},
+		},
+		{
+			name: "shared by two containers",
+			ops: []op{
+				{add: true, mountID: "a", count: 1},
+				{add: true, mountID: "b", count: 2},
+				{mountID: "a", removed: true, count: 1},
+				{mountID: "b", removed: true, count: 0},
+			},
+		},
+		{
+			name: "same mount id twice",
+			ops: []op{
+				{add: true, mountID: "a", count: 1},
+				{add: true, mountID: "a", count: 1},
+				{mountID: "a", removed: true, count: 0},
+			},
+		},
+		{
+			name: "unknown mount id",
+			ops: []op{
+				{add: true, mountID: "a", count: 1},
+				{mountID: "b", removed: false, count: 1},
+			},
+		},
+		{
+			name: "unknown volume",
+			ops: []op{
+				{mountID: "a", removed: false, count: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		m, err := newMountStore("")
+		if err != nil {
+			t.Fatalf("%s: newMountStore: %v", tt.name, err)
+		}
+		for i, o := range tt.ops {
+			if o.add {
+				m.add("vol", "name", "/mnt/name", o.mountID)
+			} else if removed := m.remove("vol", o.mountID); removed != o.removed {
+				t.Errorf("%s: op %d: remove returned %v, expected %v", tt.name, i, removed, o.removed)
+			}
+			if count := m.count("vol"); count != o.count {
+				t.Errorf("%s: op %d: count %d, expected %d", tt.name, i, count, o.count)
+			}
+		}
+		// Volumes are forgotten once they have no references
+		if _, ok := m.volumes["vol"]; ok != (m.count("vol") != 0) {
+			t.Errorf("%s: volume in the store %v with %d references",
+				tt.name, ok, m.count("vol"))
+		}
+	}
+}
+
+func TestMountStoreMountpoint(t *testing.T) {
+	m, err := newMountStore("")
+	if err != nil {
+		t.Fatalf("newMountStore: %v", err)
+	}
+
+	m.add("vol", "name", "/mnt/name", "a")
+	if mp := m.mountpoint("vol"); mp != "/mnt/name" {
+		t.Errorf("mountpoint %q, expected /mnt/name", mp)
+	}
+	m.remove("vol", "a")
+	if mp := m.mountpoint("vol"); mp != "" {
+		t.Errorf("mountpoint %q after the last unmount, expected none", mp)
+	}
+}
+
+func TestMountStoreAttached(t *testing.T) {
+	m, err := newMountStore("")
+	if err != nil {
+		t.Fatalf("newMountStore: %v", err)
+	}
+
+	// Attached volumes are kept without references, to be detached
+	m.setAttached("vol", "name", "vol")
+	m.add("vol", "name", "/mnt/name", "a")
+	m.remove("vol", "a")
+	v, ok := m.volumes["vol"]
+	if !ok {
+		t.Fatalf("attached volume forgotten after the last unmount")
+	}
+	if v.Mountpoint != "" {
+		t.Errorf("mountpoint %q after the last unmount, expected none", v.Mountpoint)
+	}
+
+	if attached := m.attached("vol"); attached != "vol" {
+		t.Errorf("attached %q, expected vol", attached)
+	}
+
+	m.setAttached("vol", "name", "")
+	if _, ok := m.volumes["vol"]; ok {
+		t.Errorf("detached volume without references still in the store")
+	}
+}
+
+func TestMountStoreRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := newMountStore(dir)
+	if err != nil {
+		t.Fatalf("newMountStore: %v", err)
+	}
+	m.setAttached("vol", "name", "sibling")
+	m.add("vol", "name", "/mnt/name", "a")
+	m.add("other", "other", "/mnt/other", "b")
+	m.remove("other", "b")
+
+	// The state is reloaded when the gateway restarts, to be reconciled
+	restarted, err := newMountStore(dir)
+	if err != nil {
+		t.Fatalf("newMountStore after restart: %v", err)
+	}
+	if len(restarted.volumes) != 1 {
+		t.Fatalf("%d volumes after restart, expected 1", len(restarted.volumes))
+	}
+	v, ok := restarted.volumes["vol"]
+	if !ok {
+		t.Fatalf("volume lost after restart")
+	}
+	if v.Name != "name" || v.Mountpoint != "/mnt/name" ||
+		v.AttachedID != "sibling" || len(v.MountIDs) != 1 {
+		t.Errorf("state %+v after restart", v)
+	}
+	if _, ok := v.MountIDs["a"]; !ok {
+		t.Errorf("mount id a lost after restart")
+	}
+}
+
+func TestMountStoreLockVolume(t *testing.T) {
+	m, err := newMountStore("")
+	if err != nil {
+		t.Fatalf("newMountStore: %v", err)
+	}
+
+	unlock, err := m.lockVolume(context.Background(), "vol")
+	if err != nil {
+		t.Fatalf("lockVolume: %v", err)
+	}
+
+	// Other volumes are not blocked
+	unlockOther, err := m.lockVolume(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("lockVolume of another volume: %v", err)
+	}
+	unlockOther()
+
+	// The volume is blocked until the lock is released
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.lockVolume(ctx, "vol"); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("locked volume: error %v, expected deadline exceeded", err)
+	}
+
+	unlock()
+	unlock, err = m.lockVolume(context.Background(), "vol")
+	if err != nil {
+		t.Fatalf("lockVolume after unlock: %v", err)
+	}
+	unlock()
+
+	if len(m.volumeLocks) != 0 {
+		t.Errorf("%d volume locks left after unlocking", len(m.volumeLocks))
+	}
+}
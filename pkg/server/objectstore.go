@@ -40,13 +40,13 @@ func (c *clusterApi) objectStoreInspect(w http.ResponseWriter, r *http.Request)
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	objInfo, err := inst.ObjectStoreInspect(objstoreID)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -85,13 +85,13 @@ func (c *clusterApi) objectStoreCreate(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	objInfo, err := inst.ObjectStoreCreate(volumeName[0])
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -137,19 +137,19 @@ func (c *clusterApi) objectStoreUpdate(w http.ResponseWriter, r *http.Request) {
 
 	enable, err := strconv.ParseBool(strEnable[0])
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.ObjectStoreUpdate(objstoreID, enable)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -185,13 +185,13 @@ func (c *clusterApi) objectStoreDelete(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.ObjectStoreDelete(objstoreID)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -29,12 +29,12 @@ func (c *clusterApi) getClusterConf(w http.ResponseWriter, r *http.Request) {
 	method := "getClusterConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	config, err := inst.GetClusterConf()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(config)
@@ -64,13 +64,13 @@ func (c *clusterApi) getNodeConf(w http.ResponseWriter, r *http.Request) {
 	method := "getNodeConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	vars := mux.Vars(r)
 	config, err := inst.GetNodeConf(vars["id"])
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(config)
@@ -94,12 +94,12 @@ func (c *clusterApi) enumerateConf(w http.ResponseWriter, r *http.Request) {
 	method := "enumerateConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	config, err := inst.EnumerateNodeConf()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(config)
@@ -127,12 +127,12 @@ func (c *clusterApi) delNodeConf(w http.ResponseWriter, r *http.Request) {
 	method := "delNodeConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	vars := mux.Vars(r)
 	if err := inst.DeleteNodeConf(vars["id"]); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 }
@@ -162,13 +162,13 @@ func (c *clusterApi) setClusterConf(w http.ResponseWriter, r *http.Request) {
 	method := "setClusterConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	if len(data) > 2 {
@@ -180,17 +180,17 @@ func (c *clusterApi) setClusterConf(w http.ResponseWriter, r *http.Request) {
 
 	data, err = base64.StdEncoding.DecodeString(string(data))
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	config := new(osdconfig.ClusterConfig)
 	if err := json.Unmarshal(data, config); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	if err := inst.SetClusterConf(config); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(config)
@@ -219,13 +219,13 @@ func (c *clusterApi) setNodeConf(w http.ResponseWriter, r *http.Request) {
 	method := "setNodeConf"
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	if len(data) > 2 {
@@ -237,17 +237,17 @@ func (c *clusterApi) setNodeConf(w http.ResponseWriter, r *http.Request) {
 
 	data, err = base64.StdEncoding.DecodeString(string(data))
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	config := new(osdconfig.NodeConfig)
 	if err := json.Unmarshal(data, config); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	if err := inst.SetNodeConf(config); err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(config)
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OwnerGroupsOpt is a comma separated list of groups with access to the volume
+	OwnerGroupsOpt = "owner_groups"
+	// CollaboratorsOpt is a comma separated list of users with access to the volume
+	CollaboratorsOpt = "collaborators"
+	// PublicOpt gives access to the volume to all users when set to true
+	PublicOpt = "public"
+
+	// ownershipPublicGroup is the group used to give access to all users
+	ownershipPublicGroup = "*"
+)
+
+// ownershipFromOpts returns the ownership of the volume requested in the
+// volume options. The owner is set by the SDK server from the token.
+func ownershipFromOpts(opts map[string]string) (*api.Ownership, error) {
+	var groups, collaborators []string
+
+	if v := opts[OwnerGroupsOpt]; len(v) != 0 {
+		groups = splitList(v)
+	}
+	if v := opts[CollaboratorsOpt]; len(v) != 0 {
+		collaborators = splitList(v)
+	}
+	if v, ok := opts[PublicOpt]; ok {
+		public, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %s for %s: %v", v, PublicOpt, err)
+		}
+		if public {
+			groups = append(groups, ownershipPublicGroup)
+		}
+	}
+
+	if len(groups) == 0 && len(collaborators) == 0 {
+		return nil, nil
+	}
+	return &api.Ownership{
+		Acls: &api.Ownership_AccessControl{
+			Groups:        groups,
+			Collaborators: collaborators,
+		},
+	}, nil
+}
+
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// accessError returns a readable error if the SDK server denied access to
+// the volume. Other errors are returned unchanged.
+func accessError(name string, err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.PermissionDenied:
+		return fmt.Errorf("Permission denied: volume %s is not owned by or shared with this user: %s",
+			name, s.Message())
+	case codes.Unauthenticated:
+		return fmt.Errorf("Access to volume %s requires a valid token: %s",
+			name, s.Message())
+	}
+	return err
+}
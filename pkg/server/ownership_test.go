@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOwnershipFromOpts(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          map[string]string
+		groups        []string
+		collaborators []string
+		none          bool
+		err           bool
+	}{
+		{
+			name: "no options",
+			opts: map[string]string{},
+			none: true,
+		},
+		{
+			name:   "groups",
+			opts:   map[string]string{OwnerGroupsOpt: "dev, ops,"},
+			groups: []string{"dev", "ops"},
+		},
+		{
+			name:          "collaborators",
+			opts:          map[string]string{CollaboratorsOpt: "alice,bob"},
+			collaborators: []string{"alice", "bob"},
+		},
+		{
+			name:   "public",
+			opts:   map[string]string{PublicOpt: "true"},
+			groups: []string{ownershipPublicGroup},
+		},
+		{
+			name: "public and groups",
+			opts: map[string]string{
+				OwnerGroupsOpt:   "dev",
+				CollaboratorsOpt: "alice",
+				PublicOpt:        "1",
+			},
+			groups:        []string{"dev", ownershipPublicGroup},
+			collaborators: []string{"alice"},
+		},
+		{
+			name: "not public",
+			opts: map[string]string{PublicOpt: "false"},
+			none: true,
+		},
+		{
+			name: "empty lists",
+			opts: map[string]string{OwnerGroupsOpt: " , ", CollaboratorsOpt: ""},
+			none: true,
+		},
+		{
+			name: "invalid public",
+			opts: map[string]string{PublicOpt: "everyone"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		ownership, err := ownershipFromOpts(tt.opts)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, expected error %v", tt.name, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if (ownership == nil) != tt.none {
+			t.Errorf("%s: ownership %v, expected none %v", tt.name, ownership, tt.none)
+			continue
+		}
+		if tt.none {
+			continue
+		}
+		acls := ownership.GetAcls()
+		if !equalLists(acls.GetGroups(), tt.groups) {
+			t.Errorf("%s: groups %v, expected %v", tt.name, acls.GetGroups(), tt.groups)
+		}
+		if !equalLists(acls.GetCollaborators(), tt.collaborators) {
+			t.Errorf("%s: collaborators %v, expected %v",
+				tt.name, acls.GetCollaborators(), tt.collaborators)
+		}
+	}
+}
+
+func TestAccessError(t *testing.T) {
+	other := fmt.Errorf("other error")
+	tests := []struct {
+		name   string
+		err    error
+		prefix string
+	}{
+		{
+			name:   "permission denied",
+			err:    status.Error(codes.PermissionDenied, "Access denied to volume vol"),
+			prefix: "Permission denied: volume myvol",
+		},
+		{
+			name:   "unauthenticated",
+			err:    status.Error(codes.Unauthenticated, "missing token"),
+			prefix: "Access to volume myvol requires a valid token",
+		},
+		{
+			name:   "other status",
+			err:    status.Error(codes.Internal, "failed"),
+			prefix: "rpc error: code = Internal",
+		},
+		{
+			name:   "not a status",
+			err:    other,
+			prefix: other.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		if err := accessError("myvol", tt.err); !strings.HasPrefix(err.Error(), tt.prefix) {
+			t.Errorf("%s: error %q, expected prefix %q", tt.name, err, tt.prefix)
+		}
+	}
+}
+
+// equalLists returns true if the lists have the same elements, nil being the
+// same as empty
+func equalLists(a, b []string) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
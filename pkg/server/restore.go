@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libopenstorage/openstorage/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// FromSnapshotOpt creates the volume from a snapshot id or name
+	FromSnapshotOpt = "from_snapshot"
+	// FromBackupOpt creates the volume by restoring a cloud backup id
+	FromBackupOpt = "from_backup"
+	// CredOpt is the credential id used to access the cloud backup
+	CredOpt = "cred"
+
+	restorePollInterval = 5 * time.Second
+	// restoreResponseMargin is the time kept to answer Docker when a request
+	// stops waiting for a restore
+	restoreResponseMargin = 5 * time.Second
+)
+
+// sourceOpts are the only options accepted when creating a volume from a
+// snapshot or a backup, which takes the spec, labels and ownership of the
+// snapshot or backup.
+var sourceOpts = map[string]bool{
+	FromSnapshotOpt: true,
+	FromBackupOpt:   true,
+	CredOpt:         true,
+	api.Name:        true,
+	api.Token:       true,
+}
+
+// checkSourceOpts returns an error listing the options requested by the user
+// which cannot be applied to a volume created from a snapshot or a backup.
+func checkSourceOpts(keys map[string]bool) error {
+	invalid := make([]string, 0)
+	for k := range keys {
+		if !sourceOpts[k] {
+			invalid = append(invalid, k)
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	sort.Strings(invalid)
+	return fmt.Errorf("Options %s cannot be used with %s or %s, the volume "+
+		"is created with the spec, labels and ownership of the snapshot or backup",
+		strings.Join(invalid, ", "), FromSnapshotOpt, FromBackupOpt)
+}
+
+// snapshotID returns the id of the snapshot with the given id or name
+func (d *driver) snapshotID(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	snapshot string,
+) (string, error) {
+	vol, err := d.inspectVolume(ctx, volumes, snapshot)
+	if err != nil {
+		return "", fmt.Errorf("Failed to locate snapshot %s: %v", snapshot, err)
+	}
+	if !vol.IsSnapshot() {
+		return "", fmt.Errorf("Volume %s is not a snapshot", snapshot)
+	}
+	return vol.GetId(), nil
+}
+
+// restoreBackup starts the restore of the cloud backup into a new volume and
+// returns the id of the volume.
+func (d *driver) restoreBackup(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	name, backupID, credID string,
+) (string, error) {
+	method := "restore"
+	resp, err := api.NewOpenStorageCloudBackupClient(conn).Restore(ctx, &api.SdkCloudBackupRestoreRequest{
+		BackupId:          backupID,
+		RestoreVolumeName: name,
+		CredentialId:      credID,
+	})
+	if err != nil {
+		return "", err
+	}
+	d.logRequest(method, name).Infof("restoring backup %s to volume %s",
+		backupID, resp.GetRestoreVolumeId())
+	return resp.GetRestoreVolumeId(), nil
+}
+
+// restoreStatus returns the status of the restore into the volume, or nil if
+// the volume is not being restored.
+func restoreStatus(
+	ctx context.Context,
+	backups api.OpenStorageCloudBackupClient,
+	id string,
+) (*api.SdkCloudBackupStatus, error) {
+	resp, err := backups.Status(ctx, &api.SdkCloudBackupStatusRequest{
+		VolumeId: id,
+	})
+	if err != nil {
+		// Drivers without cloud backups have no restores
+		if s, ok := status.FromError(err); ok && s.Code() == codes.Unimplemented {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// The statuses are keyed by volume or task id depending on the driver
+	for key, s := range resp.GetStatuses() {
+		if s.GetOptype() == api.SdkCloudBackupOpType_SdkCloudBackupOpTypeRestoreOp &&
+			(key == id || s.GetSrcVolumeId() == id) {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// waitRestore waits for the restore into the volume to complete, returning
+// an error if it failed or if it is still in progress when the request of
+// operation is about to time out. Volumes which are not being restored
+// return at once.
+func (d *driver) waitRestore(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	operation, name, id string,
+) error {
+	method := "restore"
+	backups := api.NewOpenStorageCloudBackupClient(conn)
+
+	// Keep some time to answer Docker
+	waitCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithDeadline(ctx, deadline.Add(-restoreResponseMargin))
+		defer cancel()
+	}
+
+	for {
+		s, err := restoreStatus(ctx, backups, id)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return nil
+		}
+		switch s.GetStatus() {
+		case api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeDone:
+			d.logRequest(method, name).Infof("restored backup %s to volume %s",
+				s.GetBackupId(), id)
+			return nil
+		case api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeFailed,
+			api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeAborted,
+			api.SdkCloudBackupStatusType_SdkCloudBackupStatusTypeStopped:
+			return fmt.Errorf("Restore of backup %s to volume %s did not complete: %v",
+				s.GetBackupId(), name, s.GetStatus())
+		}
+		d.logRequest(method, name).Debugf("restore status %v, %d bytes done",
+			s.GetStatus(), s.GetBytesDone())
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return contextError(ctx.Err())
+			}
+			return fmt.Errorf("Timed out after %v waiting for the restore of backup %s "+
+				"to volume %s (%d of %d bytes done). The restore continues, retry the "+
+				"%s when it completes", d.timeout(operation), s.GetBackupId(), name,
+				s.GetBytesDone(), s.GetBytesTotal(), operation)
+		case <-time.After(restorePollInterval):
+		}
+	}
+}
@@ -15,6 +15,7 @@ func (c *clusterApi) Routes() []*Route {
 		{verb: "GET", path: clusterPath("/status", cluster.APIVersion), fn: c.status},
 		{verb: "GET", path: clusterPath("/peerstatus", cluster.APIVersion), fn: c.peerStatus},
 		{verb: "GET", path: clusterPath("/inspect/{id}", cluster.APIVersion), fn: c.inspect},
+		{verb: "GET", path: clusterPath("/setsize", cluster.APIVersion), fn: c.setSize},
 		{verb: "DELETE", path: clusterPath("", cluster.APIVersion), fn: c.delete},
 		{verb: "DELETE", path: clusterPath("/{id}", cluster.APIVersion), fn: c.delete},
 		{verb: "PUT", path: clusterPath("/enablegossip", cluster.APIVersion), fn: c.enableGossip},
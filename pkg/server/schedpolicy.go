@@ -31,13 +31,13 @@ func (c *clusterApi) schedPolicyEnumerate(w http.ResponseWriter, r *http.Request
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	schedPolicies, err := inst.SchedPolicyEnumerate()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -76,14 +76,14 @@ func (c *clusterApi) schedPolicyGet(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	schedPolicy, err := inst.SchedPolicyGet(schedName)
 
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -121,13 +121,13 @@ func (c *clusterApi) schedPolicyCreate(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SchedPolicyCreate(schedReq.Name, schedReq.Schedule)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -164,13 +164,13 @@ func (c *clusterApi) schedPolicyUpdate(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SchedPolicyUpdate(schedReq.Name, schedReq.Schedule)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -207,13 +207,13 @@ func (c *clusterApi) schedPolicyDelete(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SchedPolicyDelete(schedName)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -38,13 +38,13 @@ func (c *clusterApi) setDefaultSecretKey(w http.ResponseWriter, r *http.Request)
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SecretSetDefaultSecretKey(secReq.DefaultSecretKey, secReq.Override)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -70,13 +70,13 @@ func (c *clusterApi) getDefaultSecretKey(w http.ResponseWriter, r *http.Request)
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	secretValue, err := inst.SecretGetDefaultSecretKey()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -116,7 +116,7 @@ func (c *clusterApi) secretsLogin(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -126,7 +126,7 @@ func (c *clusterApi) secretsLogin(w http.ResponseWriter, r *http.Request) {
 			c.sendError(c.name, method, w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -174,13 +174,13 @@ func (c *clusterApi) setSecret(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SecretSet(secretID[0], secReq.SecretValue)
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -219,13 +219,13 @@ func (c *clusterApi) getSecret(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	secretValue, err := inst.SecretGet(secretID[0])
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
@@ -252,13 +252,13 @@ func (c *clusterApi) secretLoginCheck(w http.ResponseWriter, r *http.Request) {
 
 	inst, err := clustermanager.Inst()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
 	err = inst.SecretCheckLogin()
 	if err != nil {
-		c.sendError(c.name, method, w, err.Error(), http.StatusInternalServerError)
+		c.sendStatusError(c.name, method, w, err)
 		return
 	}
 
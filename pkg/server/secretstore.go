@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/api"
+)
+
+const (
+	// SecretKeyOpt is the volume option used to reference the secret of an
+	// encrypted volume instead of passing its passphrase. The spec handler
+	// parses it as the passphrase of the volume, so the plugin moves it out
+	// of the spec before creating or attaching the volume.
+	SecretKeyOpt = api.SpecPassphrase
+	// PassphraseOpt is rejected in the volume options and names, since
+	// Docker shows both in docker volume inspect.
+	PassphraseOpt = "passphrase"
+
+	// SecretProviderFile reads each secret from a file in a directory
+	SecretProviderFile = "file"
+	// SecretProviderEnv reads each secret from an environment variable
+	SecretProviderEnv = "env"
+	// SecretProviderKV reads the secrets from a JSON key/value file
+	SecretProviderKV = "kv"
+
+	secretEnvPrefix = "OSD_SECRET_"
+)
+
+var (
+	passphraseRegex = regexp.MustCompile("(^|,)" + PassphraseOpt + "=")
+)
+
+// SecretProvider resolves the secret keys referenced by the volumes into the
+// secrets used to attach encrypted volumes.
+type SecretProvider interface {
+	// GetSecret returns the secret for the key
+	GetSecret(key string) (string, error)
+}
+
+// NewSecretProvider returns a secret provider of the given type:
+//   - SecretProviderFile: location is a directory with one file per key.
+//   - SecretProviderEnv: the secret is in the environment variable
+//     OSD_SECRET_<KEY>. location is not used.
+//   - SecretProviderKV: location is a JSON file with a map of keys to
+//     secrets.
+func NewSecretProvider(providerType, location string) (SecretProvider, error) {
+	switch providerType {
+	case SecretProviderFile:
+		if len(location) == 0 {
+			return nil, fmt.Errorf("Secret provider %s requires a directory", providerType)
+		}
+		return &fileSecrets{dir: location}, nil
+	case SecretProviderEnv:
+		return &envSecrets{}, nil
+	case SecretProviderKV:
+		if len(location) == 0 {
+			return nil, fmt.Errorf("Secret provider %s requires a file", providerType)
+		}
+		return &kvSecrets{file: location}, nil
+	default:
+		return nil, fmt.Errorf("Unknown secret provider %s. Must be one of %s, %s, or %s",
+			providerType, SecretProviderFile, SecretProviderEnv, SecretProviderKV)
+	}
+}
+
+// rejectPassphrase returns an error if a passphrase is passed in the volume
+// name or options, where Docker would store and show it.
+func rejectPassphrase(name string, opts map[string]string) error {
+	_, inOpts := opts[PassphraseOpt]
+	if inOpts || passphraseRegex.MatchString(name) {
+		return fmt.Errorf("Passphrases are not accepted since Docker shows them in "+
+			"docker volume inspect. Use %s=<key> to reference a secret of the "+
+			"gateway secret provider instead", SecretKeyOpt)
+	}
+	return nil
+}
+
+type fileSecrets struct {
+	dir string
+}
+
+func (s *fileSecrets) GetSecret(key string) (string, error) {
+	// Do not allow the key to escape the secrets directory
+	if strings.Contains(key, "/") || key == "." || key == ".." {
+		return "", fmt.Errorf("Invalid secret key %s", key)
+	}
+	data, err := ioutil.ReadFile(path.Join(s.dir, key))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("Secret key %s not found", key)
+	} else if err != nil {
+		return "", fmt.Errorf("Failed to read secret key %s: %v", key, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+type envSecrets struct{}
+
+func (s *envSecrets) GetSecret(key string) (string, error) {
+	name := secretEnvPrefix + strings.ToUpper(
+		strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if v, ok := os.LookupEnv(name); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("Secret key %s not found in %s", key, name)
+}
+
+type kvSecrets struct {
+	file string
+}
+
+func (s *kvSecrets) GetSecret(key string) (string, error) {
+	// Read the file every time so that secrets can be added or rotated
+	// without restarting the gateway.
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read secrets from %s: %v", s.file, err)
+	}
+	kv := make(map[string]string)
+	if err := json.Unmarshal(data, &kv); err != nil {
+		return "", fmt.Errorf("Failed to parse secrets from %s: %v", s.file, err)
+	}
+	if v, ok := kv[key]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("Secret key %s not found", key)
+}
+
+// volumeSecretKey returns the secret key saved with the volume, either in
+// the labels of its locator or of its spec.
+func volumeSecretKey(vol *api.Volume) string {
+	if key, ok := vol.GetLocator().GetVolumeLabels()[SecretKeyOpt]; ok {
+		return key
+	}
+	return vol.GetSpec().GetVolumeLabels()[SecretKeyOpt]
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+)
+
+func TestNewSecretProvider(t *testing.T) {
+	tests := []struct {
+		providerType string
+		location     string
+		fails        bool
+	}{
+		{providerType: SecretProviderFile, location: "/etc/secrets"},
+		{providerType: SecretProviderFile, fails: true},
+		{providerType: SecretProviderEnv},
+		{providerType: SecretProviderKV, location: "/etc/secrets.json"},
+		{providerType: SecretProviderKV, fails: true},
+		{providerType: "vault", location: "http://vault", fails: true},
+		{fails: true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewSecretProvider(tt.providerType, tt.location)
+		if fails := err != nil; fails != tt.fails {
+			t.Errorf("provider %q at %q: error %v, expected failure %v",
+				tt.providerType, tt.location, err, tt.fails)
+		}
+	}
+}
+
+func TestRejectPassphrase(t *testing.T) {
+	tests := []struct {
+		name     string
+		volName  string
+		opts     map[string]string
+		rejected bool
+	}{
+		{name: "no passphrase", volName: "db", opts: map[string]string{"size": "10"}},
+		{name: "secret key", volName: "name=db,secret_key=db-key"},
+		{name: "option", volName: "db", opts: map[string]string{"passphrase": "p"}, rejected: true},
+		{name: "first in the name", volName: "passphrase=p,name=db", rejected: true},
+		{name: "in the name", volName: "name=db,passphrase=p", rejected: true},
+		{name: "part of another option", volName: "name=db,old_passphrase=p"},
+	}
+
+	for _, tt := range tests {
+		err := rejectPassphrase(tt.volName, tt.opts)
+		if rejected := err != nil; rejected != tt.rejected {
+			t.Errorf("%s: error %v, expected rejected %v", tt.name, err, tt.rejected)
+		}
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "db"), []byte("passphrase\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		secret string
+		fails  bool
+	}{
+		{key: "db", secret: "passphrase"},
+		{key: "missing", fails: true},
+		{key: "../db", fails: true},
+		{key: "..", fails: true},
+		{key: "/etc/passwd", fails: true},
+	}
+
+	s := &fileSecrets{dir: dir}
+	for _, tt := range tests {
+		secret, err := s.GetSecret(tt.key)
+		if fails := err != nil; fails != tt.fails || secret != tt.secret {
+			t.Errorf("key %q: secret %q error %v, expected %q failure %v",
+				tt.key, secret, err, tt.secret, tt.fails)
+		}
+	}
+}
+
+func TestEnvSecrets(t *testing.T) {
+	os.Setenv("OSD_SECRET_DB_KEY_1", "passphrase")
+	defer os.Unsetenv("OSD_SECRET_DB_KEY_1")
+
+	tests := []struct {
+		key    string
+		secret string
+		fails  bool
+	}{
+		{key: "db-key.1", secret: "passphrase"},
+		{key: "DB_KEY_1", secret: "passphrase"},
+		{key: "db-key", fails: true},
+	}
+
+	s := &envSecrets{}
+	for _, tt := range tests {
+		secret, err := s.GetSecret(tt.key)
+		if fails := err != nil; fails != tt.fails || secret != tt.secret {
+			t.Errorf("key %q: secret %q error %v, expected %q failure %v",
+				tt.key, secret, err, tt.secret, tt.fails)
+		}
+	}
+}
+
+func TestKVSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "secrets.json")
+
+	tests := []struct {
+		name    string
+		content string
+		key     string
+		secret  string
+		fails   bool
+	}{
+		{
+			name:  "missing file",
+			key:   "db",
+			fails: true,
+		},
+		{
+			name:    "key",
+			content: `{"db": "passphrase"}`,
+			key:     "db",
+			secret:  "passphrase",
+		},
+		{
+			name:    "rotated key",
+			content: `{"db": "rotated"}`,
+			key:     "db",
+			secret:  "rotated",
+		},
+		{
+			name:    "missing key",
+			content: `{"db": "rotated"}`,
+			key:     "web",
+			fails:   true,
+		},
+		{
+			name:    "invalid file",
+			content: `db=passphrase`,
+			key:     "db",
+			fails:   true,
+		},
+	}
+
+	// The file is re-read on every lookup, so each case rewrites it
+	s := &kvSecrets{file: file}
+	for _, tt := range tests {
+		if len(tt.content) != 0 {
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("%s: WriteFile: %v", tt.name, err)
+			}
+		}
+		secret, err := s.GetSecret(tt.key)
+		if fails := err != nil; fails != tt.fails || secret != tt.secret {
+			t.Errorf("%s: secret %q error %v, expected %q failure %v",
+				tt.name, secret, err, tt.secret, tt.fails)
+		}
+	}
+}
+
+func TestVolumeSecretKey(t *testing.T) {
+	tests := []struct {
+		name string
+		vol  *api.Volume
+		key  string
+	}{
+		{
+			name: "locator labels",
+			vol: &api.Volume{
+				Locator: &api.VolumeLocator{VolumeLabels: map[string]string{SecretKeyOpt: "key"}},
+				Spec:    &api.VolumeSpec{VolumeLabels: map[string]string{SecretKeyOpt: "other"}},
+			},
+			key: "key",
+		},
+		{
+			name: "spec labels",
+			vol: &api.Volume{
+				Locator: &api.VolumeLocator{},
+				Spec:    &api.VolumeSpec{VolumeLabels: map[string]string{SecretKeyOpt: "key"}},
+			},
+			key: "key",
+		},
+		{
+			name: "not encrypted",
+			vol:  &api.Volume{},
+		},
+	}
+
+	for _, tt := range tests {
+		if key := volumeSecretKey(tt.vol); key != tt.key {
+			t.Errorf("%s: secret key %q, expected %q", tt.name, key, tt.key)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
@@ -50,24 +53,39 @@ func StartPluginAPI(
 	pluginBase string,
 	mgmtPort uint16,
 	pluginPort uint16,
+	opts *DriverOptions,
 ) error {
 	if err := StartVolumePluginAPI(
 		pluginName, driverName, sdkUds,
 		pluginBase,
 		pluginPort,
+		opts,
+	); err != nil {
+		return err
+	}
+	if err := StartVolumeMgmtAPI(
+		driverName, pluginName,
+		mgmtBase,
+		mgmtPort,
 	); err != nil {
 		return err
 	}
 	return nil
 }
 
-// StartVolumeMgmtAPI starts a REST server to receive volume management API commands
+// StartVolumeMgmtAPI starts a REST server to receive volume management API
+// commands. The commands are sent to the SDK server of the running volume
+// plugin pluginName.
 func StartVolumeMgmtAPI(
-	name string,
+	name, pluginName string,
 	mgmtBase string,
 	mgmtPort uint16,
 ) error {
-	volMgmtApi := newVolumeAPI(name)
+	plugin, err := runningPlugin(pluginName)
+	if err != nil {
+		return err
+	}
+	volMgmtApi := newVolumeAPI(name, plugin)
 	if err := startServer(
 		name,
 		mgmtBase,
@@ -79,9 +97,42 @@ func StartVolumeMgmtAPI(
 	return nil
 }
 
-func GetVolumeAPIRoutes(name string) []*Route {
-	volMgmtApi := newVolumeAPI(name)
-	return volMgmtApi.Routes()
+// GetVolumeAPIRoutes returns the routes of the volume management API for the
+// running volume plugin pluginName.
+func GetVolumeAPIRoutes(name, pluginName string) ([]*Route, error) {
+	plugin, err := runningPlugin(pluginName)
+	if err != nil {
+		return nil, err
+	}
+	volMgmtApi := newVolumeAPI(name, plugin)
+	return volMgmtApi.Routes(), nil
+}
+
+var (
+	pluginsLock sync.Mutex
+	plugins     = make(map[string]*driver)
+)
+
+// runningPlugin returns the volume plugin started as pluginName
+func runningPlugin(pluginName string) (*driver, error) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	d, ok := plugins[pluginName]
+	if !ok {
+		return nil, fmt.Errorf("Volume plugin %s is not running", pluginName)
+	}
+	return d, nil
+}
+
+// ReloadPluginOptions applies new options to a running volume plugin. Only
+// the scope, the default token file, the secret provider, the timeouts and
+// the volume defaults are changed.
+func ReloadPluginOptions(pluginName string, opts *DriverOptions) error {
+	d, err := runningPlugin(pluginName)
+	if err != nil {
+		return err
+	}
+	return d.setOptions(opts)
 }
 
 // StartVolumePluginAPI starts a REST server to receive volume API commands
@@ -90,8 +141,12 @@ func StartVolumePluginAPI(
 	pluginName, driverName, sdkUds string,
 	pluginBase string,
 	pluginPort uint16,
+	opts *DriverOptions,
 ) error {
-	volPluginApi := newVolumePlugin(driverName, sdkUds)
+	volPluginApi, err := newVolumePlugin(driverName, sdkUds, opts)
+	if err != nil {
+		return err
+	}
 	if err := startServer(
 		pluginName,
 		pluginBase,
@@ -101,13 +156,21 @@ func StartVolumePluginAPI(
 		return err
 	}
 
+	pluginsLock.Lock()
+	plugins[pluginName] = volPluginApi
+	pluginsLock.Unlock()
 	return nil
 }
 
 // StartClusterAPI starts a REST server to receive driver configuration commands
-// from the CLI/UX to control the OSD cluster.
-func StartClusterAPI(clusterApiBase string, clusterPort uint16) error {
-	clusterApi := newClusterAPI()
+// from the CLI/UX to control the OSD cluster. The cluster and node commands
+// are sent to the SDK server of the running volume plugin pluginName.
+func StartClusterAPI(pluginName, clusterApiBase string, clusterPort uint16) error {
+	plugin, err := runningPlugin(pluginName)
+	if err != nil {
+		return err
+	}
+	clusterApi := newClusterAPI(plugin)
 
 	// start server as before
 	if err := startServer("osd", clusterApiBase, clusterPort, clusterApi.Routes()); err != nil {
@@ -117,9 +180,100 @@ func StartClusterAPI(clusterApiBase string, clusterPort uint16) error {
 	return nil
 }
 
-func GetClusterAPIRoutes() []*Route {
-	clusterApi := newClusterAPI()
-	return clusterApi.Routes()
+// GetClusterAPIRoutes returns the routes of the cluster API for the running
+// volume plugin pluginName.
+func GetClusterAPIRoutes(pluginName string) ([]*Route, error) {
+	plugin, err := runningPlugin(pluginName)
+	if err != nil {
+		return nil, err
+	}
+	clusterApi := newClusterAPI(plugin)
+	return clusterApi.Routes(), nil
+}
+
+// runningServer is a REST server started by startServer
+type runningServer struct {
+	name    string
+	socket  string
+	servers []*http.Server
+}
+
+var (
+	serversLock sync.Mutex
+	servers     []*runningServer
+	serveErrors = make(chan error, 16)
+)
+
+// Errors returns a channel receiving the errors of the REST servers which
+// stopped serving requests before Shutdown was called.
+func Errors() <-chan error {
+	return serveErrors
+}
+
+// Shutdown stops the REST servers from accepting new requests and waits for
+// the requests in progress to complete until ctx expires. The sockets are
+// removed and the connections to the SDK endpoints are closed.
+func Shutdown(ctx context.Context) error {
+	serversLock.Lock()
+	stopping := servers
+	servers = nil
+	serversLock.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errsLock sync.Mutex
+		errs     []string
+	)
+	for _, rs := range stopping {
+		for _, srv := range rs.servers {
+			wg.Add(1)
+			go func(name string, srv *http.Server) {
+				defer wg.Done()
+				if err := srv.Shutdown(ctx); err != nil {
+					errsLock.Lock()
+					errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+					errsLock.Unlock()
+				}
+			}(rs.name, srv)
+		}
+	}
+	wg.Wait()
+
+	for _, rs := range stopping {
+		logrus.Infof("Stopped REST service on socket : %+v", rs.socket)
+		if err := os.Remove(rs.socket); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	pluginsLock.Lock()
+	for name, d := range plugins {
+		if err := d.sdk.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+		delete(plugins, name)
+	}
+	pluginsLock.Unlock()
+
+	if len(errs) != 0 {
+		return fmt.Errorf("Failed to shutdown cleanly: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// serve serves requests on listener until the server is shut down. Other
+// errors are sent to the Errors channel.
+func serve(name string, srv *http.Server, listener net.Listener) {
+	err := srv.Serve(listener)
+	if err == nil || err == http.ErrServerClosed {
+		return
+	}
+	err = fmt.Errorf("REST service %s on %s stopped: %v", name, listener.Addr(), err)
+	logrus.Errorln(err)
+	select {
+	case serveErrors <- err:
+	default:
+	}
 }
 
 func startServer(name string, sockBase string, port uint16, routes []*Route) error {
@@ -142,10 +296,32 @@ func startServer(name string, sockBase string, port uint16, routes []*Route) err
 		logrus.Warnln("Cannot listen on UNIX socket: ", err)
 		return err
 	}
-	go http.Serve(listener, router)
+	rs := &runningServer{
+		name:    name,
+		socket:  socket,
+		servers: []*http.Server{{Handler: router}},
+	}
+
+	var tcpListener net.Listener
 	if port != 0 {
 		logrus.Printf("Starting REST service on port : %v", port)
-		go http.ListenAndServe(fmt.Sprintf(":%d", port), router)
+		tcpListener, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			logrus.Warnln("Cannot listen on port: ", err)
+			listener.Close()
+			os.Remove(socket)
+			return err
+		}
+		rs.servers = append(rs.servers, &http.Server{Handler: router})
+	}
+
+	serversLock.Lock()
+	servers = append(servers, rs)
+	serversLock.Unlock()
+
+	go serve(name, rs.servers[0], listener)
+	if tcpListener != nil {
+		go serve(name, rs.servers[1], tcpListener)
 	}
 	return nil
 }
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/credentials"
+)
+
+// TLSOptions are the settings used to connect to the SDK endpoints with TLS.
+// They are not used for endpoints on a unix domain socket.
+type TLSOptions struct {
+	// CAFile is the CA bundle used to verify the SDK server. If empty, the
+	// system CAs are used.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key used for
+	// mutual TLS. Both must be set to send a client certificate.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the SDK server
+	ServerName string
+}
+
+// Enabled returns true if TLS must be used for the SDK endpoints
+func (o *TLSOptions) Enabled() bool {
+	return o != nil &&
+		(len(o.CAFile) != 0 || len(o.CertFile) != 0 || len(o.KeyFile) != 0 ||
+			len(o.ServerName) != 0)
+}
+
+// fileVersion identifies the content of a file by its modification time and
+// size.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileVersion, error) {
+	if len(path) == 0 {
+		return fileVersion{}, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// tlsCredentials are the gRPC transport credentials for the SDK endpoints.
+// The certificates are read again on each handshake if the files changed, so
+// they can be renewed without restarting the gateway. Established
+// connections keep using the certificates they were created with.
+type tlsCredentials struct {
+	lock     sync.Mutex
+	opts     TLSOptions
+	config   *tls.Config
+	versions [3]fileVersion
+}
+
+func newTLSCredentials(opts *TLSOptions) (*tlsCredentials, error) {
+	if (len(opts.CertFile) == 0) != (len(opts.KeyFile) == 0) {
+		return nil, fmt.Errorf("Both the client certificate and key must be provided")
+	}
+
+	c := &tlsCredentials{opts: *opts}
+	if _, err := c.tlsConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// tlsConfig returns the TLS configuration, loading the files again if they
+// changed. If they cannot be loaded, the last configuration is used.
+func (c *tlsCredentials) tlsConfig() (*tls.Config, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var versions [3]fileVersion
+	var err error
+	for i, path := range []string{c.opts.CAFile, c.opts.CertFile, c.opts.KeyFile} {
+		if versions[i], err = statFile(path); err != nil {
+			break
+		}
+	}
+	if err == nil && c.config != nil && versions == c.versions {
+		return c.config, nil
+	}
+
+	var config *tls.Config
+	if err == nil {
+		config, err = c.load()
+	}
+	if err != nil {
+		if c.config == nil {
+			return nil, err
+		}
+		logrus.Warnf("Cannot reload the TLS certificates, using the previous ones: %v", err)
+		return c.config, nil
+	}
+
+	if c.config != nil {
+		logrus.Infof("Reloaded the TLS certificates for the SDK endpoints")
+	}
+	c.config = config
+	c.versions = versions
+	return c.config, nil
+}
+
+// load reads the TLS configuration from the files
+func (c *tlsCredentials) load() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: c.opts.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(c.opts.CAFile) != 0 {
+		data, err := ioutil.ReadFile(c.opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read CA bundle %s: %v", c.opts.CAFile, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificate found in CA bundle %s", c.opts.CAFile)
+		}
+	}
+
+	if len(c.opts.CertFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot load client certificate %s: %v", c.opts.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (c *tlsCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(config).ClientHandshake(ctx, authority, conn)
+}
+
+func (c *tlsCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("TLS credentials of the gateway cannot be used by a server")
+}
+
+func (c *tlsCredentials) Info() credentials.ProtocolInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.opts.ServerName,
+	}
+}
+
+func (c *tlsCredentials) Clone() credentials.TransportCredentials {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &tlsCredentials{opts: c.opts, config: c.config, versions: c.versions}
+}
+
+func (c *tlsCredentials) OverrideServerName(serverNameOverride string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.opts.ServerName = serverNameOverride
+	c.config = nil
+	return nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a new self-signed certificate and its key
+func writeCertificate(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+// certificateName returns the name of the client certificate of the config
+func certificateName(t *testing.T, c *tlsCredentials) string {
+	config, err := c.tlsConfig()
+	if err != nil {
+		t.Fatalf("tlsConfig: %v", err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestTLSOptionsEnabled(t *testing.T) {
+	tests := []struct {
+		opts    *TLSOptions
+		enabled bool
+	}{
+		{opts: nil},
+		{opts: &TLSOptions{}},
+		{opts: &TLSOptions{CAFile: "ca.pem"}, enabled: true},
+		{opts: &TLSOptions{CertFile: "cert.pem", KeyFile: "key.pem"}, enabled: true},
+		{opts: &TLSOptions{ServerName: "osd"}, enabled: true},
+	}
+
+	for _, tt := range tests {
+		if enabled := tt.opts.Enabled(); enabled != tt.enabled {
+			t.Errorf("%+v: enabled %v, expected %v", tt.opts, enabled, tt.enabled)
+		}
+	}
+}
+
+func TestTLSCredentialsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+
+	if _, err := newTLSCredentials(&TLSOptions{CertFile: certFile}); err == nil {
+		t.Errorf("credentials created with a certificate and no key")
+	}
+	if _, err := newTLSCredentials(&TLSOptions{CertFile: certFile, KeyFile: keyFile}); err == nil {
+		t.Errorf("credentials created without the certificate files")
+	}
+
+	writeCertificate(t, certFile, keyFile, "first")
+	c, err := newTLSCredentials(&TLSOptions{
+		CAFile:   certFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatalf("newTLSCredentials: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		update  func()
+		subject string
+	}{
+		{
+			name:    "unchanged",
+			update:  func() {},
+			subject: "first",
+		},
+		{
+			name:    "renewed",
+			update:  func() { writeCertificate(t, certFile, keyFile, "renewed") },
+			subject: "renewed",
+		},
+		{
+			name: "new modification time",
+			update: func() {
+				// The files are detected as changed even with an older time
+				writeCertificate(t, certFile, keyFile, "rotated")
+				past := time.Now().Add(-time.Minute)
+				for _, f := range []string{certFile, keyFile} {
+					if err := os.Chtimes(f, past, past); err != nil {
+						t.Fatalf("Chtimes: %v", err)
+					}
+				}
+			},
+			subject: "rotated",
+		},
+		{
+			name: "invalid certificate",
+			update: func() {
+				if err := ioutil.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			},
+			subject: "rotated",
+		},
+		{
+			name:    "removed certificate",
+			update:  func() { os.Remove(certFile) },
+			subject: "rotated",
+		},
+	}
+
+	for _, tt := range tests {
+		tt.update()
+		if subject := certificateName(t, c); subject != tt.subject {
+			t.Errorf("%s: certificate %s, expected %s", tt.name, subject, tt.subject)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tokenFile provides the default token of the gateway from a file. The file
+// is read again whenever it changes so that the token can be rotated without
+// restarting the gateway.
+type tokenFile struct {
+	lock    sync.Mutex
+	path    string
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func newTokenFile(path string) *tokenFile {
+	return &tokenFile{path: path}
+}
+
+// get returns the token in the file. If the file cannot be read, the last
+// token read is returned.
+func (t *tokenFile) get() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		logrus.Warnf("Cannot access token file %s: %v", t.path, err)
+		return t.token
+	}
+	if info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.token
+	}
+
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		logrus.Warnf("Cannot read token file %s: %v", t.path, err)
+		return t.token
+	}
+	t.token = strings.TrimSpace(string(data))
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+	logrus.Infof("Loaded default token from %s", t.path)
+	return t.token
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "token")
+
+	tests := []struct {
+		name    string
+		content string
+		modTime time.Time
+		remove  bool
+		token   string
+	}{
+		{
+			name:    "token",
+			content: "token1\n",
+			modTime: time.Unix(1000, 0),
+			token:   "token1",
+		},
+		{
+			name:    "rotated with a new size",
+			content: "rotated-token\n",
+			modTime: time.Unix(1000, 0),
+			token:   "rotated-token",
+		},
+		{
+			name:    "rotated with a new mtime",
+			content: "rotated-other\n",
+			modTime: time.Unix(2000, 0),
+			token:   "rotated-other",
+		},
+		{
+			name:    "unchanged",
+			content: "rotated-other\n",
+			modTime: time.Unix(2000, 0),
+			token:   "rotated-other",
+		},
+		{
+			name:   "removed",
+			remove: true,
+			token:  "rotated-other",
+		},
+	}
+
+	tf := newTokenFile(file)
+	for _, tt := range tests {
+		if tt.remove {
+			os.Remove(file)
+		} else {
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("%s: WriteFile: %v", tt.name, err)
+			}
+			if err := os.Chtimes(file, tt.modTime, tt.modTime); err != nil {
+				t.Fatalf("%s: Chtimes: %v", tt.name, err)
+			}
+		}
+		if token := tf.get(); token != tt.token {
+			t.Errorf("%s: token %q, expected %q", tt.name, token, tt.token)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/libopenstorage/openstorage/api"
+	"github.com/libopenstorage/openstorage/api/errors"
+)
+
+// specField compares one field of the volume spec set by a volume option
+type specField struct {
+	opt string
+	get func(spec *api.VolumeSpec) interface{}
+}
+
+var specFields = []specField{
+	{api.SpecSize, func(s *api.VolumeSpec) interface{} { return s.GetSize() }},
+	{api.SpecHaLevel, func(s *api.VolumeSpec) interface{} { return s.GetHaLevel() }},
+	{api.SpecFilesystem, func(s *api.VolumeSpec) interface{} { return s.GetFormat() }},
+	{api.SpecBlockSize, func(s *api.VolumeSpec) interface{} { return s.GetBlockSize() }},
+	{api.SpecPriority, func(s *api.VolumeSpec) interface{} { return s.GetCos() }},
+	{api.SpecIoProfile, func(s *api.VolumeSpec) interface{} { return s.GetIoProfile() }},
+	{api.SpecShared, func(s *api.VolumeSpec) interface{} { return s.GetShared() }},
+	{api.SpecSharedv4, func(s *api.VolumeSpec) interface{} { return s.GetSharedv4() }},
+	{api.SpecScale, func(s *api.VolumeSpec) interface{} { return s.GetScale() }},
+	{api.SpecSticky, func(s *api.VolumeSpec) interface{} { return s.GetSticky() }},
+	{api.SpecSecure, func(s *api.VolumeSpec) interface{} { return s.GetEncrypted() }},
+	{api.SpecCompressed, func(s *api.VolumeSpec) interface{} { return s.GetCompressed() }},
+	{api.SpecCascaded, func(s *api.VolumeSpec) interface{} { return s.GetCascaded() }},
+	{api.SpecJournal, func(s *api.VolumeSpec) interface{} { return s.GetJournal() }},
+}
+
+// requestedKeys returns the options given by the user, either in the volume
+// name or in the volume options. It must be called before the volume
+// defaults are added to the options, since changing the defaults must not
+// make existing volumes conflict.
+func requestedKeys(specParsed bool, name string, opts map[string]string) map[string]bool {
+	keys := make(map[string]bool)
+	if !specParsed {
+		for k := range opts {
+			keys[k] = true
+		}
+		return keys
+	}
+	for _, kv := range strings.Split(name, ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			keys[kv[:i]] = true
+		}
+	}
+	return keys
+}
+
+// specDifferences returns the fields requested by the user which differ from
+// the existing volume.
+func specDifferences(
+	keys map[string]bool,
+	spec *api.VolumeSpec,
+	source *api.Source,
+	vol *api.Volume,
+) []string {
+	diffs := make([]string, 0)
+	for _, f := range specFields {
+		if !keys[f.opt] {
+			continue
+		}
+		requested, existing := f.get(spec), f.get(vol.GetSpec())
+		if requested != existing {
+			diffs = append(diffs, fmt.Sprintf("%s (requested %v, existing %v)",
+				f.opt, requested, existing))
+		}
+	}
+
+	if requested := spec.GetVolumeLabels()[SecretKeyOpt]; len(requested) != 0 {
+		if existing := volumeSecretKey(vol); requested != existing {
+			diffs = append(diffs, fmt.Sprintf("%s (requested %v, existing %v)",
+				SecretKeyOpt, requested, existing))
+		}
+	}
+
+	if requested := source.GetParent(); len(requested) != 0 {
+		if existing := vol.GetSource().GetParent(); requested != existing {
+			diffs = append(diffs, fmt.Sprintf("parent (requested %v, existing %v)",
+				requested, existing))
+		}
+	}
+	return diffs
+}
+
+// existingVolume checks if a volume with the same name already exists. It
+// returns the volume if it exists with a spec compatible with the request,
+// nil if it does not exist, and an error describing the differences if it
+// exists with a different spec.
+func (d *driver) existingVolume(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	name string,
+	keys map[string]bool,
+	spec *api.VolumeSpec,
+	source *api.Source,
+) (*api.Volume, error) {
+	vol, err := d.inspectVolume(ctx, volumes, name)
+	if err != nil {
+		if _, ok := err.(*errors.ErrNotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if diffs := specDifferences(keys, spec, source, vol); len(diffs) != 0 {
+		return nil, fmt.Errorf("Volume %s already exists with a different spec: %s",
+			name, strings.Join(diffs, ", "))
+	}
+	return vol, nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/libopenstorage/openstorage/api"
+)
+
+func TestRequestedKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		specParsed bool
+		volName    string
+		opts       map[string]string
+		expected   map[string]bool
+	}{
+		{
+			name:     "options",
+			volName:  "myvol",
+			opts:     map[string]string{api.SpecSize: "10", api.SpecHaLevel: "2"},
+			expected: map[string]bool{api.SpecSize: true, api.SpecHaLevel: true},
+		},
+		{
+			name:     "no options",
+			volName:  "myvol",
+			expected: map[string]bool{},
+		},
+		{
+			name:       "spec in the name",
+			specParsed: true,
+			volName:    "name=myvol,size=10,repl=2",
+			opts:       map[string]string{api.SpecShared: "true"},
+			expected:   map[string]bool{"name": true, api.SpecSize: true, api.SpecHaLevel: true},
+		},
+		{
+			name:       "spec in the name without value",
+			specParsed: true,
+			volName:    "name=myvol,shared",
+			expected:   map[string]bool{"name": true},
+		},
+	}
+
+	for _, tt := range tests {
+		keys := requestedKeys(tt.specParsed, tt.volName, tt.opts)
+		if !reflect.DeepEqual(keys, tt.expected) {
+			t.Errorf("%s: keys %v, expected %v", tt.name, keys, tt.expected)
+		}
+	}
+}
+
+func TestSpecDifferences(t *testing.T) {
+	existing := &api.Volume{
+		Id: "vol",
+		Locator: &api.VolumeLocator{
+			Name: "myvol",
+		},
+		Spec: &api.VolumeSpec{
+			Size:    10,
+			HaLevel: 2,
+			Shared:  true,
+			VolumeLabels: map[string]string{
+				SecretKeyOpt: "key",
+			},
+		},
+		Source: &api.Source{
+			Parent: "snap",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		keys     map[string]bool
+		spec     *api.VolumeSpec
+		source   *api.Source
+		expected []string
+	}{
+		{
+			name:     "same spec",
+			keys:     map[string]bool{api.SpecSize: true, api.SpecHaLevel: true},
+			spec:     &api.VolumeSpec{Size: 10, HaLevel: 2},
+			expected: []string{},
+		},
+		{
+			name:     "different size",
+			keys:     map[string]bool{api.SpecSize: true},
+			spec:     &api.VolumeSpec{Size: 20},
+			expected: []string{"size (requested 20, existing 10)"},
+		},
+		{
+			name:     "fields not requested are ignored",
+			keys:     map[string]bool{api.SpecSize: true},
+			spec:     &api.VolumeSpec{Size: 10, HaLevel: 3, Shared: false},
+			expected: []string{},
+		},
+		{
+			name:     "several differences",
+			keys:     map[string]bool{api.SpecHaLevel: true, api.SpecShared: true},
+			spec:     &api.VolumeSpec{HaLevel: 3, Shared: false},
+			expected: []string{"repl (requested 3, existing 2)", "shared (requested false, existing true)"},
+		},
+		{
+			name: "same secret key in the spec labels",
+			keys: map[string]bool{SecretKeyOpt: true},
+			spec: &api.VolumeSpec{
+				VolumeLabels: map[string]string{SecretKeyOpt: "key"},
+			},
+			expected: []string{},
+		},
+		{
+			name: "different secret key",
+			keys: map[string]bool{SecretKeyOpt: true},
+			spec: &api.VolumeSpec{
+				VolumeLabels: map[string]string{SecretKeyOpt: "other"},
+			},
+			expected: []string{SecretKeyOpt + " (requested other, existing key)"},
+		},
+		{
+			name:     "same parent",
+			keys:     map[string]bool{},
+			spec:     &api.VolumeSpec{},
+			source:   &api.Source{Parent: "snap"},
+			expected: []string{},
+		},
+		{
+			name:     "different parent",
+			keys:     map[string]bool{},
+			spec:     &api.VolumeSpec{},
+			source:   &api.Source{Parent: "other"},
+			expected: []string{"parent (requested other, existing snap)"},
+		},
+	}
+
+	for _, tt := range tests {
+		diffs := specDifferences(tt.keys, tt.spec, tt.source, existing)
+		if !reflect.DeepEqual(diffs, tt.expected) {
+			t.Errorf("%s: differences %q, expected %q", tt.name, diffs, tt.expected)
+		}
+	}
+}
+
+func TestSpecDifferencesSecretKeyInLocator(t *testing.T) {
+	// Volumes created by the gateway keep the secret key in the locator labels
+	existing := &api.Volume{
+		Locator: &api.VolumeLocator{
+			Name:         "myvol",
+			VolumeLabels: map[string]string{SecretKeyOpt: "key"},
+		},
+		Spec: &api.VolumeSpec{},
+	}
+	spec := &api.VolumeSpec{
+		VolumeLabels: map[string]string{SecretKeyOpt: "key"},
+	}
+	if diffs := specDifferences(map[string]bool{}, spec, nil, existing); len(diffs) != 0 {
+		t.Errorf("differences %q for the same secret key", diffs)
+	}
+}
@@ -1,35 +1,44 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/libopenstorage/openstorage/api"
 	"github.com/libopenstorage/openstorage/api/errors"
-	clustermanager "github.com/libopenstorage/openstorage/cluster/manager"
+	"github.com/libopenstorage/openstorage/pkg/options"
 	"github.com/libopenstorage/openstorage/volume"
 	"github.com/libopenstorage/openstorage/volume/drivers"
+	"google.golang.org/grpc"
 )
 
 const schedDriverPostFix = "-sched"
 
+// volAPI serves the volume management REST API. Its handlers translate the
+// requests to the SDK, using the connections of the volume plugin.
 type volAPI struct {
 	restBase
+	plugin *driver
 }
 
 func responseStatus(err error) string {
 	if err == nil {
 		return ""
 	}
-	return err.Error()
+	return translateError(err).Error()
 }
 
-func newVolumeAPI(name string) restServer {
-	return &volAPI{restBase{version: volume.APIVersion, name: name}}
+func newVolumeAPI(name string, plugin *driver) restServer {
+	return &volAPI{restBase{version: volume.APIVersion, name: name}, plugin}
 }
 
 func (vd *volAPI) String() string {
@@ -59,6 +68,42 @@ func (vd *volAPI) getVolDriver(r *http.Request) (volume.VolumeDriver, error) {
 	return volumedrivers.Get(vd.name)
 }
 
+// bearerOptions returns the options with the token of the Authorization
+// header of a management request, if any.
+func bearerOptions(r *http.Request) map[string]string {
+	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(auth) == 2 && strings.EqualFold(auth[0], "bearer") {
+		return map[string]string{api.Token: strings.TrimSpace(auth[1])}
+	}
+	return nil
+}
+
+// requestContext returns the context used for the SDK calls of a request.
+// The token is taken from the Authorization header, or from the default
+// token file of the plugin.
+func (vd *volAPI) requestContext(
+	r *http.Request,
+	method string,
+) (context.Context, context.CancelFunc) {
+	return vd.plugin.requestContext(r, method, "", bearerOptions(r))
+}
+
+// encodeResponse sends resp with the HTTP status code matching err. Legacy
+// clients read the error from resp.
+func (vd *volAPI) encodeResponse(
+	method, id string,
+	w http.ResponseWriter,
+	resp interface{},
+	err error,
+) {
+	if err != nil {
+		code := httpStatus(err)
+		vd.logRequest(method, id).Warnln(code, " ", responseStatus(err))
+		w.WriteHeader(code)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
 func (vd *volAPI) parseID(r *http.Request) (string, error) {
 	if id, err := vd.parseParam(r, "id"); err == nil {
 		return id, nil
@@ -75,37 +120,73 @@ func (vd *volAPI) parseParam(r *http.Request, param string) (string, error) {
 	return "", fmt.Errorf("could not parse %s", param)
 }
 
-func (vd *volAPI) nodeIPtoIds(nodes []string) ([]string, error) {
+func (vd *volAPI) nodeIPtoIds(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	nodes []string,
+) ([]string, error) {
 	nodeIds := make([]string, 0)
 
-	// Get cluster instance
-	c, err := clustermanager.Inst()
-	if err != nil {
-		return nodeIds, err
+	var ipToID map[string]string
+	for _, idIp := range nodes {
+		if idIp == "" {
+			continue
+		}
+		if net.ParseIP(idIp) == nil {
+			nodeIds = append(nodeIds, idIp)
+			continue
+		}
+
+		// Only look up the nodes of the cluster once
+		if ipToID == nil {
+			var err error
+			if ipToID, err = nodeIPs(ctx, conn); err != nil {
+				return nodeIds, err
+			}
+		}
+		id, ok := ipToID[idIp]
+		if !ok {
+			return nodeIds, fmt.Errorf("Failed to locate node with IP %s", idIp)
+		}
+		nodeIds = append(nodeIds, id)
 	}
 
-	if c == nil {
-		return nodeIds, fmt.Errorf("failed to get cluster instance.")
+	return nodeIds, nil
+}
+
+// nodeIPs returns the ids of the nodes in the cluster by their IPs
+func nodeIPs(ctx context.Context, conn *grpc.ClientConn) (map[string]string, error) {
+	nodes := api.NewOpenStorageNodeClient(conn)
+	enumResp, err := nodes.Enumerate(ctx, &api.SdkNodeEnumerateRequest{})
+	if err != nil {
+		return nil, err
 	}
 
-	for _, idIp := range nodes {
-		if idIp != "" {
-			id, err := c.GetNodeIdFromIp(idIp)
-			if err != nil {
-				return nodeIds, err
+	ipToID := make(map[string]string)
+	for _, id := range enumResp.GetNodeIds() {
+		inspResp, err := nodes.Inspect(ctx, &api.SdkNodeInspectRequest{NodeId: id})
+		if err != nil {
+			return nil, err
+		}
+		node := inspResp.GetNode()
+		for _, ip := range []string{node.GetMgmtIp(), node.GetDataIp()} {
+			if len(ip) != 0 {
+				ipToID[ip] = id
 			}
-			nodeIds = append(nodeIds, id)
 		}
 	}
-
-	return nodeIds, err
+	return ipToID, nil
 }
 
 // Convert any replica set node values which are IPs to the corresponding Node ID.
 // Update the replica set node list.
-func (vd *volAPI) updateReplicaSpecNodeIPstoIds(rspecRef *api.ReplicaSet) error {
+func (vd *volAPI) updateReplicaSpecNodeIPstoIds(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	rspecRef *api.ReplicaSet,
+) error {
 	if rspecRef != nil && len(rspecRef.Nodes) > 0 {
-		nodeIds, err := vd.nodeIPtoIds(rspecRef.Nodes)
+		nodeIds, err := vd.nodeIPtoIds(ctx, conn, rspecRef.Nodes)
 		if err != nil {
 			return err
 		}
@@ -118,6 +199,61 @@ func (vd *volAPI) updateReplicaSpecNodeIPstoIds(rspecRef *api.ReplicaSet) error
 	return nil
 }
 
+// inspectVolumes returns the volumes with the given ids
+func inspectVolumes(
+	ctx context.Context,
+	volumes api.OpenStorageVolumeClient,
+	ids []string,
+) ([]*api.Volume, error) {
+	vols := make([]*api.Volume, 0, len(ids))
+	for _, id := range ids {
+		resp, err := volumes.Inspect(ctx, &api.SdkVolumeInspectRequest{
+			VolumeId: id,
+		})
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, resp.GetVolume())
+	}
+	return vols, nil
+}
+
+// specUpdate returns the changes to apply to a volume for the fields set in
+// spec. Fields with their zero value are not changed.
+func specUpdate(spec *api.VolumeSpec) *api.VolumeSpecUpdate {
+	update := &api.VolumeSpecUpdate{
+		ReplicaSet: spec.GetReplicaSet(),
+		Ownership:  spec.GetOwnership(),
+	}
+	if spec.GetSize() != 0 {
+		update.SizeOpt = &api.VolumeSpecUpdate_Size{Size: spec.GetSize()}
+	}
+	if spec.GetHaLevel() != 0 {
+		update.HaLevelOpt = &api.VolumeSpecUpdate_HaLevel{HaLevel: spec.GetHaLevel()}
+	}
+	if spec.GetCos() != api.CosType_NONE {
+		update.CosOpt = &api.VolumeSpecUpdate_Cos{Cos: spec.GetCos()}
+	}
+	if spec.GetIoProfile() != api.IoProfile_IO_PROFILE_SEQUENTIAL {
+		update.IoProfileOpt = &api.VolumeSpecUpdate_IoProfile{IoProfile: spec.GetIoProfile()}
+	}
+	if spec.GetScale() != 0 {
+		update.ScaleOpt = &api.VolumeSpecUpdate_Scale{Scale: spec.GetScale()}
+	}
+	if spec.GetSnapshotInterval() != 0 {
+		update.SnapshotIntervalOpt = &api.VolumeSpecUpdate_SnapshotInterval{
+			SnapshotInterval: spec.GetSnapshotInterval(),
+		}
+	}
+	if spec.GetShared() {
+		update.SharedOpt = &api.VolumeSpecUpdate_Shared{Shared: true}
+	}
+	if spec.GetSticky() {
+		update.StickyOpt = &api.VolumeSpecUpdate_Sticky{Sticky: true}
+	}
+	return update
+}
+
 // swagger:operation POST /osd-volumes volume createVolume
 //
 // Creates a single volume with given spec.
@@ -151,27 +287,59 @@ func (vd *volAPI) create(w http.ResponseWriter, r *http.Request) {
 		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if dcReq.Locator == nil || len(dcReq.Locator.Name) == 0 {
+		vd.sendError(vd.name, method, w, "Missing volume name", http.StatusBadRequest)
+		return
+	}
+	if dcReq.Spec == nil {
+		dcReq.Spec = &api.VolumeSpec{}
+	}
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 
-	if dcReq.Spec != nil {
-		if err = vd.updateReplicaSpecNodeIPstoIds(dcReq.Spec.ReplicaSet); err != nil {
-			vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
-			return
+	if err = vd.updateReplicaSpecNodeIPstoIds(ctx, conn, dcReq.Spec.ReplicaSet); err != nil {
+		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The SDK saves the labels of the locator with the spec
+	if len(dcReq.Locator.VolumeLabels) != 0 {
+		if dcReq.Spec.VolumeLabels == nil {
+			dcReq.Spec.VolumeLabels = make(map[string]string)
+		}
+		for k, v := range dcReq.Locator.VolumeLabels {
+			dcReq.Spec.VolumeLabels[k] = v
 		}
 	}
 
-	id, err := d.Create(dcReq.Locator, dcReq.Source, dcReq.Spec)
+	volumes := api.NewOpenStorageVolumeClient(conn)
+	if parent := dcReq.GetSource().GetParent(); len(parent) != 0 {
+		var resp *api.SdkVolumeCloneResponse
+		resp, err = volumes.Clone(ctx, &api.SdkVolumeCloneRequest{
+			Name:     dcReq.Locator.Name,
+			ParentId: parent,
+		})
+		dcRes.Id = resp.GetVolumeId()
+	} else {
+		var resp *api.SdkVolumeCreateResponse
+		resp, err = volumes.Create(ctx, &api.SdkVolumeCreateRequest{
+			Name: dcReq.Locator.Name,
+			Spec: dcReq.Spec,
+		})
+		dcRes.Id = resp.GetVolumeId()
+	}
 	dcRes.VolumeResponse = &api.VolumeResponse{Error: responseStatus(err)}
-	dcRes.Id = id
 
-	vd.logRequest(method, id).Infoln("")
+	vd.logRequest(method, dcRes.Id).Infoln("")
 
-	json.NewEncoder(w).Encode(&dcRes)
+	vd.encodeResponse(method, dcReq.Locator.Name, w, &dcRes, err)
 }
 
 func processErrorForVolSetResponse(action *api.VolumeStateAction, err error, resp *api.VolumeSetResponse) {
@@ -187,12 +355,12 @@ func processErrorForVolSetResponse(action *api.VolumeStateAction, err error, res
 			resp.Volume = &api.Volume{}
 		default:
 			resp.VolumeResponse = &api.VolumeResponse{
-				Error: err.Error(),
+				Error: responseStatus(err),
 			}
 		}
 	} else if err != nil {
 		resp.VolumeResponse = &api.VolumeResponse{
-			Error: err.Error(),
+			Error: responseStatus(err),
 		}
 	}
 }
@@ -252,28 +420,64 @@ func (vd *volAPI) volumeSet(w http.ResponseWriter, r *http.Request) {
 
 	vd.logRequest(method, string(volumeID)).Infoln(setActions)
 
-	d, err := vd.getVolDriver(r)
+	// Attach and mount take as long as for the volume plugin
+	timeoutMethod := method
+	if req.Action != nil {
+		timeoutMethod = "mount"
+	}
+	ctx, cancel := vd.requestContext(r, timeoutMethod)
+	defer cancel()
+
+	// Volumes are attached and mounted on the node serving the request
+	var conn *grpc.ClientConn
+	if req.Action != nil {
+		conn, err = vd.plugin.getLocalConn(ctx)
+	} else {
+		conn, err = vd.plugin.getConn(ctx)
+	}
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+	mountAttach := api.NewOpenStorageMountAttachClient(conn)
 
 	if req.Locator != nil || req.Spec != nil {
+		update := &api.SdkVolumeUpdateRequest{
+			VolumeId: volumeID,
+			Labels:   req.GetLocator().GetVolumeLabels(),
+		}
 		if req.Spec != nil {
-			if err = vd.updateReplicaSpecNodeIPstoIds(req.Spec.ReplicaSet); err != nil {
+			if err = vd.updateReplicaSpecNodeIPstoIds(ctx, conn, req.Spec.ReplicaSet); err != nil {
 				vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			update.Spec = specUpdate(req.Spec)
 		}
-		err = d.Set(volumeID, req.Locator, req.Spec)
+		_, err = volumes.Update(ctx, update)
 	}
 
 	for err == nil && req.Action != nil {
 		if req.Action.Attach != api.VolumeActionParam_VOLUME_ACTION_PARAM_NONE {
 			if req.Action.Attach == api.VolumeActionParam_VOLUME_ACTION_PARAM_ON {
-				_, err = d.Attach(volumeID, req.Options)
+				_, err = mountAttach.Attach(ctx, &api.SdkVolumeAttachRequest{
+					VolumeId: volumeID,
+					Options: &api.SdkVolumeAttachOptions{
+						SecretName:    req.Options[options.OptionsSecret],
+						SecretKey:     req.Options[options.OptionsSecretKey],
+						SecretContext: req.Options[options.OptionsSecretContext],
+					},
+					DriverOptions: req.GetOptions(),
+				})
 			} else {
-				err = d.Detach(volumeID, req.Options)
+				_, err = mountAttach.Detach(ctx, &api.SdkVolumeDetachRequest{
+					VolumeId: volumeID,
+					Options: &api.SdkVolumeDetachOptions{
+						Force:               req.Options[options.OptionsForceDetach] == "true",
+						UnmountBeforeDetach: req.Options[options.OptionsUnmountBeforeDetach] == "true",
+					},
+					DriverOptions: req.GetOptions(),
+				})
 			}
 			if err != nil {
 				break
@@ -286,9 +490,21 @@ func (vd *volAPI) volumeSet(w http.ResponseWriter, r *http.Request) {
 					err = fmt.Errorf("Invalid mount path")
 					break
 				}
-				err = d.Mount(volumeID, req.Action.MountPath, req.Options)
+				_, err = mountAttach.Mount(ctx, &api.SdkVolumeMountRequest{
+					VolumeId:      volumeID,
+					MountPath:     req.Action.MountPath,
+					DriverOptions: req.GetOptions(),
+				})
 			} else {
-				err = d.Unmount(volumeID, req.Action.MountPath, req.Options)
+				_, err = mountAttach.Unmount(ctx, &api.SdkVolumeUnmountRequest{
+					VolumeId:  volumeID,
+					MountPath: req.Action.MountPath,
+					Options: &api.SdkVolumeUnmountOptions{
+						DeleteMountPath:                req.Options[options.OptionsDeleteAfterUnmount] == "true",
+						NoDelayBeforeDeletingMountPath: req.Options[options.OptionsWaitBeforeDelete] == "false",
+					},
+					DriverOptions: req.GetOptions(),
+				})
 			}
 			if err != nil {
 				break
@@ -297,22 +513,22 @@ func (vd *volAPI) volumeSet(w http.ResponseWriter, r *http.Request) {
 		break
 	}
 
-	if err != nil {
-		processErrorForVolSetResponse(req.Action, err, &resp)
-	} else {
-		v, err := d.Inspect([]string{volumeID})
-		if err != nil {
-			processErrorForVolSetResponse(req.Action, err, &resp)
-		} else if v == nil || len(v) != 1 {
-			processErrorForVolSetResponse(req.Action, &errors.ErrNotFound{Type: "Volume", ID: volumeID}, &resp)
-		} else {
-			v0 := v[0]
-			resp.Volume = v0
+	if err == nil {
+		var v []*api.Volume
+		if v, err = inspectVolumes(ctx, volumes, []string{volumeID}); err == nil {
+			resp.Volume = v[0]
 		}
 	}
+	if isNotFound(err) {
+		err = &errors.ErrNotFound{Type: "Volume", ID: volumeID}
+	}
+	processErrorForVolSetResponse(req.Action, err, &resp)
 
-	json.NewEncoder(w).Encode(resp)
-
+	// A volume which is not found is already detached and unmounted
+	if resp.VolumeResponse == nil || len(resp.VolumeResponse.Error) == 0 {
+		err = nil
+	}
+	vd.encodeResponse(method, volumeID, w, resp, err)
 }
 
 // swagger:operation GET /osd-volumes/{id} volume inspectVolume
@@ -338,21 +554,24 @@ func (vd *volAPI) inspect(w http.ResponseWriter, r *http.Request) {
 	var volumeID string
 
 	method := "inspect"
-	d, err := vd.getVolDriver(r)
-	if err != nil {
-		notFound(w, r)
-		return
-	}
-
 	if volumeID, err = vd.parseID(r); err != nil {
 		e := fmt.Errorf("Failed to parse parse volumeID: %s", err.Error())
 		vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
 		return
 	}
 
-	dk, err := d.Inspect([]string{volumeID})
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getReadConn(ctx)
 	if err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusNotFound)
+		vd.sendStatusError(vd.name, method, w, err)
+		return
+	}
+
+	dk, err := inspectVolumes(ctx, api.NewOpenStorageVolumeClient(conn), []string{volumeID})
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 
@@ -394,18 +613,22 @@ func (vd *volAPI) delete(w http.ResponseWriter, r *http.Request) {
 
 	vd.logRequest(method, volumeID).Infoln("")
 
-	d, err := vd.getVolDriver(r)
+	ctx, cancel := vd.requestContext(r, "remove")
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 
 	volumeResponse := &api.VolumeResponse{}
 
-	if err := d.Delete(volumeID); err != nil {
-		volumeResponse.Error = err.Error()
-	}
-	json.NewEncoder(w).Encode(volumeResponse)
+	_, err = api.NewOpenStorageVolumeClient(conn).Delete(ctx, &api.SdkVolumeDeleteRequest{
+		VolumeId: volumeID,
+	})
+	volumeResponse.Error = responseStatus(err)
+	vd.encodeResponse(method, volumeID, w, volumeResponse, err)
 }
 
 // swagger:operation GET /osd-volumes volume enumerateVolumes
@@ -458,11 +681,6 @@ func (vd *volAPI) enumerate(w http.ResponseWriter, r *http.Request) {
 
 	method := "enumerate"
 
-	d, err := vd.getVolDriver(r)
-	if err != nil {
-		notFound(w, r)
-		return
-	}
 	params := r.URL.Query()
 	v := params[string(api.OptName)]
 	if v != nil {
@@ -473,6 +691,7 @@ func (vd *volAPI) enumerate(w http.ResponseWriter, r *http.Request) {
 		if err = json.Unmarshal([]byte(v[0]), &locator.VolumeLabels); err != nil {
 			e := fmt.Errorf("Failed to parse parse VolumeLabels: %s", err.Error())
 			vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
+			return
 		}
 	}
 	v = params[string(api.OptConfigLabel)]
@@ -480,30 +699,62 @@ func (vd *volAPI) enumerate(w http.ResponseWriter, r *http.Request) {
 		if err = json.Unmarshal([]byte(v[0]), &configLabels); err != nil {
 			e := fmt.Errorf("Failed to parse parse configLabels: %s", err.Error())
 			vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
+			return
 		}
 	}
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getReadConn(ctx)
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
+		return
+	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+
 	v = params[string(api.OptVolumeID)]
 	if v != nil {
 		ids := make([]string, len(v))
 		for i, s := range v {
 			ids[i] = string(s)
 		}
-		vols, err = d.Inspect(ids)
+		vols, err = inspectVolumes(ctx, volumes, ids)
 		if err != nil {
-			e := fmt.Errorf("Failed to inspect volumeID: %s", err.Error())
-			vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
+			vd.sendStatusError(vd.name, method, w, err)
 			return
 		}
 	} else {
-		vols, err = d.Enumerate(&locator, configLabels)
+		vols, err = vd.plugin.enumerateVolumes(ctx, volumes, &locator)
 		if err != nil {
-			vd.sendError(vd.name, method, w, err.Error(), http.StatusInternalServerError)
+			vd.sendStatusError(vd.name, method, w, err)
 			return
 		}
 	}
+
+	// The SDK filters only on the labels of the locator
+	if len(configLabels) != 0 {
+		filtered := make([]*api.Volume, 0, len(vols))
+		for _, vol := range vols {
+			if hasLabels(vol.GetSpec().GetVolumeLabels(), configLabels) {
+				filtered = append(filtered, vol)
+			}
+		}
+		vols = filtered
+	}
 	json.NewEncoder(w).Encode(vols)
 }
 
+// hasLabels returns true if labels contains all the labels in filter
+func hasLabels(labels, filter map[string]string) bool {
+	for k, v := range filter {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 // swagger:operation POST /osd-snapshots snapshot createSnap
 //
 // Take a snapshot of volume in SnapCreateRequest
@@ -541,22 +792,47 @@ func (vd *volAPI) snap(w http.ResponseWriter, r *http.Request) {
 		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	d, err := vd.getVolDriver(r)
+
+	vd.logRequest(method, string(snapReq.Id)).Infoln("")
+
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
 	if err != nil {
-		notFound(w, r)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 
-	vd.logRequest(method, string(snapReq.Id)).Infoln("")
+	// The SDK requires a name, which the legacy API did not
+	name := snapReq.GetLocator().GetName()
+	if len(name) == 0 {
+		name = fmt.Sprintf("%s.snap-%s", snapReq.Id, snapshotSuffix())
+	}
 
-	id, err := d.Snapshot(snapReq.Id, snapReq.Readonly, snapReq.Locator, snapReq.NoRetry)
+	// SDK snapshots are always read-only and the SDK server does its own
+	// retries, so Readonly and NoRetry are not used.
+	resp, err := api.NewOpenStorageVolumeClient(conn).SnapshotCreate(
+		ctx, &api.SdkVolumeSnapshotCreateRequest{
+			VolumeId: snapReq.Id,
+			Name:     name,
+			Labels:   snapReq.GetLocator().GetVolumeLabels(),
+		})
 	snapRes.VolumeCreateResponse = &api.VolumeCreateResponse{
-		Id: id,
+		Id: resp.GetSnapshotId(),
 		VolumeResponse: &api.VolumeResponse{
 			Error: responseStatus(err),
 		},
 	}
-	json.NewEncoder(w).Encode(&snapRes)
+	vd.encodeResponse(method, snapReq.Id, w, &snapRes, err)
+}
+
+// snapshotSuffix returns a unique suffix for the names of the snapshots
+// which are not named in the request
+func snapshotSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102150405") + "-" + hex.EncodeToString(b)
 }
 
 // swagger:operation POST /osd-snapshots/restore/{id} snapshot restoreSnap
@@ -592,12 +868,6 @@ func (vd *volAPI) restore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := vd.getVolDriver(r)
-	if err != nil {
-		notFound(w, r)
-		return
-	}
-
 	params := r.URL.Query()
 	v := params[api.OptSnapID]
 	if v != nil {
@@ -608,11 +878,23 @@ func (vd *volAPI) restore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	volumeResponse := &api.VolumeResponse{}
-	if err := d.Restore(volumeID, snapID); err != nil {
-		volumeResponse.Error = responseStatus(err)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getConn(ctx)
+	if err != nil {
+		vd.sendStatusError(vd.name, method, w, err)
+		return
 	}
-	json.NewEncoder(w).Encode(volumeResponse)
+
+	volumeResponse := &api.VolumeResponse{}
+	_, err = api.NewOpenStorageVolumeClient(conn).SnapshotRestore(
+		ctx, &api.SdkVolumeSnapshotRestoreRequest{
+			VolumeId:   volumeID,
+			SnapshotId: snapID,
+		})
+	volumeResponse.Error = responseStatus(err)
+	vd.encodeResponse(method, volumeID, w, volumeResponse, err)
 }
 
 // swagger:operation GET /osd-snapshots snapshot enumerateSnaps
@@ -663,34 +945,55 @@ func (vd *volAPI) snapEnumerate(w http.ResponseWriter, r *http.Request) {
 	var ids []string
 
 	method := "snapEnumerate"
-	d, err := vd.getVolDriver(r)
-	if err != nil {
-		notFound(w, r)
-		return
-	}
 	params := r.URL.Query()
 	v := params[string(api.OptLabel)]
 	if v != nil {
 		if err = json.Unmarshal([]byte(v[0]), &labels); err != nil {
 			e := fmt.Errorf("Failed to parse parse VolumeLabels: %s", err.Error())
 			vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
+			return
 		}
 	}
 
 	v, ok := params[string(api.OptVolumeID)]
 	if v != nil && ok {
-		ids = make([]string, len(params))
+		ids = make([]string, len(v))
 		for i, s := range v {
 			ids[i] = string(s)
 		}
+	} else {
+		// Snapshots of all the volumes
+		ids = []string{""}
 	}
 
-	snaps, err := d.SnapEnumerate(ids, labels)
+	ctx, cancel := vd.requestContext(r, method)
+	defer cancel()
+
+	conn, err := vd.plugin.getReadConn(ctx)
 	if err != nil {
-		e := fmt.Errorf("Failed to enumerate snaps: %s", err.Error())
-		vd.sendError(vd.name, method, w, e.Error(), http.StatusBadRequest)
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
+	volumes := api.NewOpenStorageVolumeClient(conn)
+
+	snaps := make([]*api.Volume, 0)
+	for _, id := range ids {
+		resp, err := volumes.SnapshotEnumerateWithFilters(
+			ctx, &api.SdkVolumeSnapshotEnumerateWithFiltersRequest{
+				VolumeId: id,
+				Labels:   labels,
+			})
+		if err != nil {
+			vd.sendStatusError(vd.name, method, w, err)
+			return
+		}
+		vols, err := inspectVolumes(ctx, volumes, resp.GetVolumeSnapshotIds())
+		if err != nil {
+			vd.sendStatusError(vd.name, method, w, err)
+			return
+		}
+		snaps = append(snaps, vols...)
+	}
 
 	json.NewEncoder(w).Encode(snaps)
 }
@@ -851,8 +1154,8 @@ func (vd *volAPI) volumeusage(w http.ResponseWriter, r *http.Request) {
 
 	capacityInfo, err := d.CapacityUsage(volumeID)
 	if err != nil {
-		e := fmt.Errorf("Failed to get CapacityUsage: %s", err.Error())
-		vd.sendError(vd.name, method, w, e.Error(), http.StatusInternalServerError)
+		// Keep the status of the SDK error for the HTTP status code
+		vd.sendStatusError(vd.name, method, w, err)
 		return
 	}
 	json.NewEncoder(w).Encode(capacityInfo)
@@ -971,7 +1274,8 @@ func (vd *volAPI) unquiesce(w http.ResponseWriter, r *http.Request) {
 
 // swagger:operation POST /osd-snapshots/groupsnap volumegroup snapVolumeGroup
 //
-// Take a snapshot of volumegroup
+// Take a snapshot of volumegroup. The SDK has no group snapshots, so this
+// returns 501 Not Implemented.
 //
 // ---
 // produces:
@@ -984,35 +1288,12 @@ func (vd *volAPI) unquiesce(w http.ResponseWriter, r *http.Request) {
 //   schema:
 //    "$ref": "#/definitions/GroupSnapCreateRequest"
 // responses:
-//   '200':
-//     description: group snap create response
-//     schema:
-//      "$ref": "#/definitions/GroupSnapCreateResponse"
-//   default:
-//     description: unexpected error
-//     schema:
-//      "$ref": "#/definitions/GroupSnapCreateResponse"
+//   '501':
+//     description: group snapshots are not supported
 func (vd *volAPI) snapGroup(w http.ResponseWriter, r *http.Request) {
-	var snapReq api.GroupSnapCreateRequest
-	var snapRes *api.GroupSnapCreateResponse
 	method := "snapGroup"
-
-	if err := json.NewDecoder(r.Body).Decode(&snapReq); err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	d, err := vd.getVolDriver(r)
-	if err != nil {
-		notFound(w, r)
-		return
-	}
-	snapRes, err = d.SnapshotGroup(snapReq.Id, snapReq.Labels)
-	if err != nil {
-		vd.sendError(vd.name, method, w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	json.NewEncoder(w).Encode(&snapRes)
+	vd.sendError(vd.name, method, w, "Group snapshots are not supported by the SDK",
+		http.StatusNotImplemented)
 }
 
 // swagger:operation GET /osd-volumes/versions volume listVersions